@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// resizer is an optional interface implemented by policies which support
+// changing their capacity.
+type resizer interface {
+	// resize sets new capacity of the policy. Entries exceeding the capacity
+	// are not evicted immediately.
+	resize(cap int)
+}
+
+func (l *lruCache) resize(cap int) {
+	l.cap = cap
+}
+
+func (l *slruCache) resize(cap int) {
+	l.protectedCap = int(float64(cap) * protectedRatio)
+	l.probationCap = cap - l.protectedCap
+}
+
+func (l *tinyLFU) resize(cap int) {
+	if l.samples > 0 {
+		l.samples = l.sampleSize(cap)
+	}
+	lruCap := int(float64(cap) * admissionRatio)
+	l.lru.resize(lruCap)
+	l.slru.resize(cap - lruCap)
+}
+
+func (l *priorityCache) resize(cap int) {
+	l.cap = cap
+}
+
+func (l *lfuCache) resize(cap int) {
+	l.cap = cap
+}
+
+func (l *sampledCache) resize(cap int) {
+	l.cap = cap
+}
+
+func (l *mruCache) resize(cap int) {
+	l.cap = cap
+}
+
+// adaptSizePeriodically adjusts the cache capacity until the cache is closed.
+func (c *localCache) adaptSizePeriodically() {
+	ticker := time.NewTicker(adaptiveInterval)
+	defer ticker.Stop()
+	var last Stats
+	c.stats.Snapshot(&last)
+	for {
+		select {
+		case <-ticker.C:
+			var st Stats
+			c.stats.Snapshot(&st)
+			c.call(func() {
+				c.adaptSize(&last, &st)
+			})
+			last = st
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// adaptSize adjusts the cache capacity using stats changes since the last time.
+// This function must only be called from processEntries goroutine.
+func (c *localCache) adaptSize(last, st *Stats) {
+	cap := c.capacity()
+	evictions := st.EvictionCount - last.EvictionCount
+	hits := st.HitCount - last.HitCount
+	requests := st.RequestCount() - last.RequestCount()
+
+	newCap := cap
+	if evictions > 0 && requests > 0 && float64(hits) < adaptiveHitRate*float64(requests) {
+		newCap = cap + (cap+9)/10
+		if newCap > c.adaptiveMax {
+			newCap = c.adaptiveMax
+		}
+	} else if evictions == 0 && c.cache.len() < cap/2 {
+		newCap = cap - (cap+9)/10
+		if newCap < c.adaptiveMin {
+			newCap = c.adaptiveMin
+		}
+	}
+	if newCap != cap {
+		c.resize(newCap)
+	}
+}
+
+// resize changes the cache capacity and evicts entries exceeding the new capacity.
+// This function must only be called from processEntries goroutine.
+func (c *localCache) resize(cap int) {
+	atomic.StoreInt32(&c.cap, int32(cap))
+	if p, ok := c.accessQueue.(resizer); ok {
+		p.resize(cap)
+	}
+	for c.cache.len() > cap {
+		var victim *entry
+		fn := func(en *entry) bool {
+			if en.getPinned() {
+				return true
+			}
+			victim = en
+			return false
+		}
+		if p, ok := c.accessQueue.(evictionOrderer); ok {
+			p.evictionOrder(fn)
+		} else {
+			c.accessQueue.iterate(fn)
+		}
+		if victim == nil {
+			return
+		}
+		c.remove(victim)
+		c.recordEviction(EvictionSize)
+		c.pressure.current(c.now()).evictions++
+	}
+}
@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestAdaptiveSize(t *testing.T) {
+	wg := sync.WaitGroup{}
+	insFunc := func(Key, Value) {
+		wg.Done()
+	}
+	c := New(WithMaximumSize(10), WithAdaptiveSize(5, 20), WithPolicy("lru"),
+		WithInsertionListener(insFunc))
+	defer c.Close()
+	if n := c.Cap(); n != 10 {
+		t.Fatalf("unexpected cap: %d", n)
+	}
+	l := c.(*localCache)
+
+	// Evictions with low hit rate grow the cache.
+	l.call(func() {
+		l.adaptSize(&Stats{}, &Stats{MissCount: 10, EvictionCount: 5})
+	})
+	if n := c.Cap(); n != 11 {
+		t.Fatalf("unexpected cap: %d", n)
+	}
+	// High hit rate keeps the size.
+	l.call(func() {
+		l.adaptSize(&Stats{}, &Stats{HitCount: 10, EvictionCount: 5})
+	})
+	if n := c.Cap(); n != 11 {
+		t.Fatalf("unexpected cap: %d", n)
+	}
+	// No evictions and a mostly empty cache shrinks it to the minimum.
+	for i := 0; i < 5; i++ {
+		l.call(func() {
+			l.adaptSize(&Stats{}, &Stats{})
+		})
+	}
+	if n := c.Cap(); n != 5 {
+		t.Fatalf("unexpected cap: %d", n)
+	}
+
+	wg.Add(5)
+	for i := 0; i < 5; i++ {
+		c.Put(i, i)
+	}
+	wg.Wait()
+	// Shrinking evicts entries exceeding the new size.
+	l.call(func() {
+		l.resize(3)
+	})
+	if n := cacheSize(&l.cache); n != 3 {
+		t.Fatalf("unexpected cache size: %d", n)
+	}
+	if _, ok := c.GetIfPresent(0); ok {
+		t.Fatalf("entry must be evicted")
+	}
+}
+
+func TestAdaptiveSizeBounds(t *testing.T) {
+	c := New(WithAdaptiveSize(5, 20))
+	defer c.Close()
+	if n := c.Cap(); n != 5 {
+		t.Fatalf("unexpected cap: %d", n)
+	}
+	c2 := New(WithMaximumSize(100), WithAdaptiveSize(5, 20))
+	defer c2.Close()
+	if n := c2.Cap(); n != 20 {
+		t.Fatalf("unexpected cap: %d", n)
+	}
+}
@@ -57,8 +57,26 @@ func BenchmarkHotspot(b *testing.B) {
 	benchmarkCache(b, g)
 }
 
-func benchmarkCache(b *testing.B, g synthetic.Generator) {
-	c := New(WithMaximumSize(testMaxSize))
+func BenchmarkZipfLFU(b *testing.B) {
+	items := testMaxSize * 10
+	g := synthetic.Zipf(0, items, 1.01)
+	benchmarkCache(b, g, WithPolicy("lfu"))
+}
+
+func BenchmarkZipfLRU(b *testing.B) {
+	items := testMaxSize * 10
+	g := synthetic.Zipf(0, items, 1.01)
+	benchmarkCache(b, g, WithPolicy("lru"))
+}
+
+func BenchmarkZipfSampled(b *testing.B) {
+	items := testMaxSize * 10
+	g := synthetic.Zipf(0, items, 1.01)
+	benchmarkCache(b, g, WithPolicy("sampled"))
+}
+
+func benchmarkCache(b *testing.B, g synthetic.Generator, options ...Option) {
+	c := New(append([]Option{WithMaximumSize(testMaxSize)}, options...)...)
 	defer c.Close()
 
 	intCh := make(chan int, 100)
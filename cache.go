@@ -2,6 +2,11 @@
 // including support for LRU, Segmented LRU and TinyLFU.
 package cache
 
+import (
+	"io"
+	"time"
+)
+
 // Key is any value which is comparable.
 // See http://golang.org/ref/spec#Comparison_operators for details.
 type Key interface{}
@@ -11,28 +16,212 @@ type Value interface{}
 
 // Cache is a key-value cache which entries are added and stayed in the
 // cache until either are evicted or manually invalidated.
+//
+// Listeners are called by the goroutine maintaining the cache, so they must
+// not call the methods which wait for that goroutine, as it would deadlock:
+// InvalidateTag, InvalidateAll, Clear, Compact, RangeByAccessOrder, Drain,
+// EvictionPressure, EvictionOrder and ExpiredCountEstimate.
 type Cache interface {
 	// GetIfPresent returns value associated with Key or (nil, false)
 	// if there is no cached value for Key.
 	GetIfPresent(Key) (Value, bool)
 
+	// GetEntry returns a snapshot of the entry associated with Key and its
+	// metadata, or (nil, false) if there is no cached value for Key.
+	GetEntry(Key) (*EntryView, bool)
+
+	// TouchIfPresent marks the entry of Key accessed and returns true if it
+	// is present. It does not record stats.
+	TouchIfPresent(Key) bool
+
+	// Peek returns value associated with Key like GetIfPresent, without
+	// any side effects: access is not recorded and stats are not changed.
+	Peek(Key) (Value, bool)
+
 	// Put associates value with Key. If a value is already associated
 	// with Key, the old one will be replaced with Value.
 	Put(Key, Value)
 
+	// PutWithTimes associates value with Key like Put, using the given write
+	// time and access time of the entry instead of the current time.
+	PutWithTimes(k Key, v Value, writeTime, accessTime time.Time)
+
+	// PutWithMeta associates value with Key like Put and attaches the given
+	// metadata to the entry, which is opaque to the cache.
+	PutWithMeta(k Key, v Value, meta interface{})
+
+	// RangeWithMeta calls the given function for each entry which is not
+	// expired, with its metadata, until the function returns false.
+	RangeWithMeta(func(k Key, v Value, meta interface{}) bool)
+
+	// GetBySecondary returns value which attribute in the secondary index of
+	// the given name equals the given attribute. See WithSecondaryIndex.
+	GetBySecondary(name, attr string) (Value, bool)
+
+	// Update replaces value associated with Key if it is present, without
+	// resetting its write time unlike Put. It returns false if Key is not present.
+	Update(Key, Value) bool
+
+	// GetOrSet returns value associated with Key if it is present, otherwise
+	// it stores and returns the value created by the given factory.
+	// The factory is called at most once for concurrent calls of the same Key.
+	GetOrSet(Key, func() Value) Value
+
+	// Append adds Value to the values associated with Key.
+	// See WithMultiValue for limiting number of values per Key.
+	Append(Key, Value)
+
+	// GetValues returns the values added by Append for Key, or nil if there
+	// is no cached value for Key.
+	GetValues(Key) []Value
+
+	// PutWithTags associates value with Key like Put and sets the tags of
+	// the entry, so that it can be discarded by InvalidateTag.
+	PutWithTags(Key, Value, ...string)
+
+	// InvalidateTag discards all entries associated with the given tag.
+	// It must not be called from a listener.
+	InvalidateTag(string)
+
+	// PutWithDependencies associates value with Key like Put and sets the
+	// keys the entry depends on, so that it is discarded when any of them
+	// is invalidated.
+	PutWithDependencies(Key, Value, ...Key)
+
 	// Invalidate discards cached value of the given Key.
 	Invalidate(Key)
 
+	// InvalidateLocal discards cached value of the given Key without
+	// calling the invalidation broadcaster.
+	InvalidateLocal(Key)
+
+	// MarkStale makes the entry of Key expired without removing it, so that
+	// it is reloaded when it is accessed next time.
+	MarkStale(Key)
+
+	// Pin prevents the cached entry of Key from being evicted when the cache
+	// is full. Pinned entries still expire and can be invalidated, which also
+	// drops the pin. When the cache is full of pinned entries, a newly added
+	// entry is evicted immediately by all built-in policies.
+	Pin(Key)
+
+	// Unpin allows the cached entry of Key to be evicted again.
+	Unpin(Key)
+
 	// InvalidateAll discards all entries.
+	// It must not be called from a listener.
 	InvalidateAll()
 
+	// Clear synchronously removes all entries and resets the cache state,
+	// calling the removal listener only if the given flag is true.
+	// It must not be called from a listener.
+	Clear(notify bool)
+
+	// Compact rebuilds internal maps of the cache to release memory of
+	// removed entries. It copies all entries, so it should be called only
+	// after most entries are removed.
+	// It must not be called from a listener.
+	Compact()
+
+	// Find returns entries which are not expired and match the predicate,
+	// up to the given number of entries if it is positive.
+	Find(pred func(Key, Value) bool, limit int) map[Key]Value
+
+	// RangeByAccessOrder calls the given function for each entry which is not
+	// expired, from the least recently accessed to the most, until the function
+	// returns false. It does not change access times of the entries.
+	// It must not be called from a listener.
+	RangeByAccessOrder(func(k Key, v Value) bool)
+
+	// Drain removes all entries and returns the ones which were not expired.
+	// It must not be called from a listener.
+	Drain() map[Key]Value
+
+	// PolicyName returns name of the eviction policy used by the cache.
+	PolicyName() string
+
+	// Cap returns the current maximum number of entries in the cache,
+	// or 0 if it is unlimited.
+	Cap() int
+
+	// Available returns the number of entries which can be added before the
+	// cache starts evicting, or math.MaxInt32 if the cache is unlimited.
+	Available() int
+
+	// SetStrictCapacity switches between the approximate and exact enforcement
+	// of the maximum size. See WithStrictCapacity.
+	SetStrictCapacity(bool)
+
+	// EvictionPressure returns the number of entries evicted because the
+	// cache is full per entry added in the last minute. A value close to 1
+	// means the cache is too small for the working set.
+	// It must not be called from a listener.
+	EvictionPressure() float64
+
+	// Utilization returns the number of entries divided by the maximum number
+	// of entries, or 0 if the cache is unlimited.
+	Utilization() float64
+
 	// Stats copies cache statistics to given Stats pointer.
 	Stats(*Stats)
 
+	// StatsSnapshot returns a copy of cache statistics by value, which does
+	// not allocate unlike passing a new Stats pointer to Stats.
+	StatsSnapshot() Stats
+
+	// Hits, Misses and Evictions return individual stats counters, which
+	// are cheaper than Stats but not consistent with each other.
+	Hits() uint64
+	Misses() uint64
+	Evictions() uint64
+
+	// PublishExpvar publishes cache statistics as an expvar variable with
+	// the given name. The name must be unique.
+	PublishExpvar(name string)
+
+	// ResetStats zeros cache statistics, which is useful to get stats
+	// per interval instead of cumulative values.
+	ResetStats()
+
+	// EvictionOrder returns up to the given number of keys in the order
+	// they would be evicted by the cache policy, without removing them.
+	// It is intended for diagnostics and is best-effort for policies which
+	// do not have a strict eviction order.
+	// It must not be called from a listener.
+	EvictionOrder(int) []Key
+
+	// ExpiredCountEstimate returns an estimated number of entries which are
+	// expired after access but not yet removed from the cache.
+	// It must not be called from a listener.
+	ExpiredCountEstimate() int
+
+	// DumpTo writes all live entries to the given writer in gob format.
+	// Keys and values must be encodable by encoding/gob.
+	DumpTo(io.Writer) error
+
+	// RestoreFrom adds entries written by DumpTo to the cache, preserving
+	// their access and write time.
+	RestoreFrom(io.Reader) error
+
+	// Health returns nil if the cache is operational, or an error if it is
+	// closed or does not respond in time. It is intended for health checks.
+	Health() error
+
 	// Close implements io.Closer for cleaning up all resources.
 	// Users must ensure the cache is not being used before closing or
 	// after closed.
 	Close() error
+
+	// CloseWithTimeout is like Close but waits up to the given duration for
+	// removal listeners to complete. On timeout, remaining entries are dropped
+	// without notifying listeners and ErrCloseTimeout is returned.
+	CloseWithTimeout(time.Duration) error
+}
+
+// Entry is a key-value pair in the cache.
+type Entry struct {
+	Key   Key
+	Value Value
 }
 
 // Func is a generic callback for entry events in the cache.
@@ -47,15 +236,64 @@ type LoadingCache interface {
 	// to load value if it is not present.
 	Get(Key) (Value, error)
 
+	// GetAndRefresh returns value associated with Key like Get and always
+	// reloads the value asynchronously afterward, regardless of its staleness.
+	// If Key does not exist, the value is loaded synchronously.
+	GetAndRefresh(Key) (Value, error)
+
+	// GetFresh returns value associated with Key like Get if it was written
+	// within the given duration, otherwise it loads the value synchronously.
+	GetFresh(Key, time.Duration) (Value, error)
+
+	// GetStrict returns value associated with Key like Get, but never returns
+	// an expired value: it is reloaded synchronously and the error of the
+	// loader is returned if it fails.
+	GetStrict(Key) (Value, error)
+
+	// GetWithRefreshCallback returns value associated with Key like Get and
+	// calls the given function with the result of the reload started by
+	// this call, if any, once it completes.
+	GetWithRefreshCallback(Key, func(Value, error)) (Value, error)
+
+	// LoadingKeys returns keys which values are being loaded or refreshed.
+	// It is best-effort as loads start and complete concurrently.
+	LoadingKeys() []Key
+
+	// WaitForLoads blocks until there are no loads or refreshes running,
+	// or the cache is closed.
+	WaitForLoads()
+
 	// Refresh loads new value for Key. If the Key already existed, the previous value
 	// will continue to be returned by Get while the new value is loading.
 	// If Key does not exist, this function will block until the value is loaded.
-	Refresh(Key)
+	// It returns false if no load was started, e.g. because the Key is
+	// already being refreshed.
+	Refresh(Key) bool
+
+	// RefreshWithTimeout is like Refresh but waits up to the given duration
+	// for the value of an absent Key to be loaded, returning ErrLoadTimeout
+	// if it is not loaded in time.
+	RefreshWithTimeout(Key, time.Duration) error
 }
 
 // LoaderFunc retrieves the value corresponding to given Key.
 type LoaderFunc func(Key) (Value, error)
 
+// RefreshFunc reloads the value corresponding to given Key, given its current
+// value and the time it was written. It can return ErrNotModified to keep
+// the current value.
+type RefreshFunc func(k Key, old Value, writtenAt time.Time) (Value, error)
+
+// Weigher returns weight of an entry, e.g. size of the value in bytes.
+type Weigher func(Key, Value) uint64
+
+// Sizer can be implemented by values to report their own weight, which is
+// used when the cache has no Weigher.
+type Sizer interface {
+	// Size returns weight of the value, e.g. its size in bytes.
+	Size() uint64
+}
+
 // Executor specifies how cache loader is run to refresh value for the Key.
 // By default, it is run in a new go routine.
 type Executor interface {
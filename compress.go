@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+)
+
+// Compression is the algorithm used to compress []byte values.
+type Compression uint8
+
+const (
+	// CompressionNone stores values as they are.
+	CompressionNone Compression = iota
+	// CompressionGzip compresses []byte values with gzip.
+	CompressionGzip
+)
+
+// compressedValue is a []byte value stored in compressed form.
+type compressedValue []byte
+
+// compress returns the compressed form of v if it is a []byte and compression
+// is enabled. Other values are returned unchanged.
+func (c *localCache) compress(v Value) Value {
+	b, ok := v.([]byte)
+	if !ok || c.compression == CompressionNone {
+		return v
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return v
+	}
+	if err := w.Close(); err != nil {
+		return v
+	}
+	if st, ok := c.stats.(CompressionStatsCounter); ok {
+		st.RecordCompression(uint64(len(b)), uint64(buf.Len()))
+	}
+	return compressedValue(buf.Bytes())
+}
+
+// decompress returns the original value of v. A value which can not be
+// decompressed is reported to the error handler and nil is returned.
+func (c *localCache) decompress(k Key, v Value) Value {
+	b, ok := v.(compressedValue)
+	if !ok {
+		return v
+	}
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err == nil {
+		var d []byte
+		if d, err = ioutil.ReadAll(r); err == nil {
+			return d
+		}
+	}
+	if c.onError != nil {
+		c.onError(k, err)
+	}
+	return nil
+}
+
+// valueOf returns the original value of the entry.
+func (c *localCache) valueOf(en *entry) Value {
+	return c.decompress(en.key, en.getValue())
+}
@@ -0,0 +1,33 @@
+package cache
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	c := New(WithCompression(CompressionGzip))
+	defer c.Close()
+
+	data := bytes.Repeat([]byte("cache"), 100)
+	c.Put(1, data)
+	c.Put(2, "text")
+	v, ok := c.GetIfPresent(1)
+	if !ok || !bytes.Equal(v.([]byte), data) {
+		t.Fatalf("unexpected value: %v", v)
+	}
+	v, ok = c.GetIfPresent(2)
+	if !ok || v != "text" {
+		t.Fatalf("unexpected value: %v", v)
+	}
+	en := c.(*localCache).cache.get(1, sum(1))
+	if b, ok := en.getValue().(compressedValue); !ok || len(b) >= len(data) {
+		t.Fatalf("value must be compressed: %v", en.getValue())
+	}
+
+	var st Stats
+	c.Stats(&st)
+	if st.UncompressedBytes != uint64(len(data)) || st.CompressionRatio() >= 1 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
@@ -0,0 +1,145 @@
+package cache
+
+import "container/list"
+
+// Policy is an eviction policy implemented outside of this package, which can
+// be set by WithCustomPolicy. It tracks keys of the cache entries and decides
+// which one is evicted when the cache is full.
+//
+// All methods are called only from the goroutine processing cache events, so
+// implementations do not need synchronization, but they must not call the
+// cache which would block waiting for that goroutine.
+// Entries pinned by Pin are not known to the policy, so they are evicted as
+// any other entries. WithAdaptiveSize is only supported by policies which also
+// implement PolicyResizer, others keep their initial maximum size.
+type Policy interface {
+	// Init initializes the policy for a cache of the given maximum number of
+	// entries, which is 0 if it is unlimited. It is called again with the same
+	// size when the cache is cleared, and must forget all keys.
+	Init(maximumSize int)
+
+	// Add adds k which is new to the cache. If the cache exceeds its maximum
+	// size, it returns the key to be evicted, which can be k itself, and true.
+	// The policy must forget the returned key, as Remove is not called for it.
+	Add(k Key) (evicted Key, ok bool)
+
+	// Hit marks k accessed. It is also called when the value of k is replaced.
+	Hit(k Key)
+
+	// Remove forgets k when it is expired or invalidated.
+	Remove(k Key)
+
+	// WalkAccess calls fn for each key, from the least recently accessed one
+	// or in the order they would be evicted, until fn returns false.
+	WalkAccess(fn func(k Key) bool)
+}
+
+// PolicyResizer can be implemented by a Policy to support WithAdaptiveSize.
+type PolicyResizer interface {
+	// Resize sets the new maximum number of entries. When it shrinks, the
+	// cache removes the exceeding keys by Remove in the order of WalkAccess.
+	Resize(maximumSize int)
+}
+
+// customPolicy adapts a Policy to the cache policy.
+type customPolicy struct {
+	p       Policy
+	cache   *cache
+	entries map[Key]*entry
+	ls      list.List
+}
+
+// init initializes the custom policy.
+func (l *customPolicy) init(c *cache, cap int) {
+	l.cache = c
+	l.entries = make(map[Key]*entry)
+	l.ls.Init()
+	if cap >= maximumCapacity {
+		cap = 0
+	}
+	l.p.Init(cap)
+}
+
+// resize sets new capacity of the policy if it implements PolicyResizer.
+func (l *customPolicy) resize(cap int) {
+	if r, ok := l.p.(PolicyResizer); ok {
+		r.Resize(cap)
+	}
+}
+
+// write adds new entry to the cache and returns evicted entry if necessary.
+func (l *customPolicy) write(en *entry) *entry {
+	// Fast path
+	if en.accessList != nil {
+		l.p.Hit(en.key)
+		return nil
+	}
+	cen := l.cache.getOrSet(en)
+	if cen != nil {
+		// Entry has already been added, update its value instead.
+		cen.copyValue(en)
+		cen.setWriteTime(en.getWriteTime())
+		if cen.accessList != nil {
+			l.p.Hit(cen.key)
+			return nil
+		}
+		// Entry is loaded to the cache but not yet registered.
+		en = cen
+	}
+	en.accessList = l.ls.PushFront(en)
+	l.entries[en.key] = en
+	k, ok := l.p.Add(en.key)
+	if !ok {
+		return nil
+	}
+	ren := l.entries[k]
+	if ren == nil {
+		return nil
+	}
+	return l.unlink(ren)
+}
+
+// access marks the entry accessed.
+func (l *customPolicy) access(en *entry) {
+	if en.accessList != nil {
+		l.p.Hit(en.key)
+	}
+}
+
+// remove removes an entry from the cache.
+func (l *customPolicy) remove(en *entry) *entry {
+	if en.accessList == nil {
+		// Already deleted
+		return nil
+	}
+	l.p.Remove(en.key)
+	return l.unlink(en)
+}
+
+// unlink removes an entry from the cache without notifying the policy.
+func (l *customPolicy) unlink(en *entry) *entry {
+	l.cache.delete(en)
+	l.ls.Remove(en.accessList)
+	en.accessList = nil
+	delete(l.entries, en.key)
+	return en
+}
+
+// iterate walks through all entries in the order given by the policy.
+// Keys are collected first as fn can remove the entries.
+func (l *customPolicy) iterate(fn func(en *entry) bool) {
+	var keys []Key
+	l.p.WalkAccess(func(k Key) bool {
+		keys = append(keys, k)
+		return true
+	})
+	for _, k := range keys {
+		en := l.entries[k]
+		if en == nil {
+			continue
+		}
+		if !fn(en) {
+			return
+		}
+	}
+}
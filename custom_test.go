@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"container/list"
+	"testing"
+)
+
+// fifoPolicy evicts the oldest added key regardless of accesses.
+type fifoPolicy struct {
+	cap  int
+	ls   list.List
+	keys map[Key]*list.Element
+	hits int
+}
+
+func (p *fifoPolicy) Init(maximumSize int) {
+	p.cap = maximumSize
+	p.ls.Init()
+	p.keys = make(map[Key]*list.Element)
+}
+
+func (p *fifoPolicy) Add(k Key) (Key, bool) {
+	p.keys[k] = p.ls.PushBack(k)
+	if p.cap > 0 && p.ls.Len() > p.cap {
+		el := p.ls.Front()
+		p.ls.Remove(el)
+		delete(p.keys, el.Value)
+		return el.Value, true
+	}
+	return nil, false
+}
+
+func (p *fifoPolicy) Resize(maximumSize int) {
+	p.cap = maximumSize
+}
+
+func (p *fifoPolicy) Hit(k Key) {
+	p.hits++
+}
+
+func (p *fifoPolicy) Remove(k Key) {
+	if el := p.keys[k]; el != nil {
+		p.ls.Remove(el)
+		delete(p.keys, k)
+	}
+}
+
+func (p *fifoPolicy) WalkAccess(fn func(Key) bool) {
+	for el := p.ls.Front(); el != nil; el = el.Next() {
+		if !fn(el.Value) {
+			return
+		}
+	}
+}
+
+func TestCustomPolicy(t *testing.T) {
+	p := &fifoPolicy{}
+	c := New(WithCustomPolicy(p), WithMaximumSize(3)).(*localCache)
+	defer c.Close()
+	if c.PolicyName() != "custom" {
+		t.Fatalf("unexpected policy name: %v", c.PolicyName())
+	}
+	for i := 0; i < 3; i++ {
+		c.Put(i, i)
+	}
+	c.GetIfPresent(0)
+	c.Put(3, 3)
+	c.call(func() {})
+	if _, ok := c.GetIfPresent(0); ok {
+		t.Fatal("expected the oldest entry evicted")
+	}
+	c.Invalidate(2)
+	c.call(func() {})
+	keys := c.EvictionOrder(10)
+	if len(keys) != 2 || keys[0] != 1 || keys[1] != 3 {
+		t.Fatalf("unexpected eviction order: %v", keys)
+	}
+	c.call(func() {
+		if p.hits != 1 || len(p.keys) != 2 {
+			t.Errorf("unexpected policy state: %d hits, %d keys", p.hits, len(p.keys))
+		}
+	})
+}
+
+func TestCustomPolicyResize(t *testing.T) {
+	p := &fifoPolicy{}
+	c := New(WithCustomPolicy(p), WithMaximumSize(4)).(*localCache)
+	defer c.Close()
+	for i := 0; i < 4; i++ {
+		c.Put(i, i)
+	}
+	c.call(func() {
+		c.resize(2)
+	})
+	c.Put(4, 4)
+	c.call(func() {})
+	keys := c.EvictionOrder(10)
+	if len(keys) != 2 || keys[0] != 3 || keys[1] != 4 {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	c.call(func() {
+		if p.cap != 2 || len(p.keys) != 2 {
+			t.Errorf("unexpected policy state: cap %d, %d keys", p.cap, len(p.keys))
+		}
+	})
+
+	u := &fifoPolicy{}
+	unlimited := New(WithCustomPolicy(u)).(*localCache)
+	defer unlimited.Close()
+	unlimited.call(func() {
+		if u.cap != 0 {
+			t.Errorf("unexpected unlimited policy size: %d", u.cap)
+		}
+	})
+}
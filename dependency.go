@@ -0,0 +1,106 @@
+package cache
+
+import "sync/atomic"
+
+// PutWithDependencies associates value with k like Put and replaces the keys
+// which the entry depends on with the given ones. When any of these keys is
+// invalidated, the entry is invalidated too, as well as the entries depending
+// on it. Keys do not need to be present in the cache. Cycles of dependencies
+// are broken by invalidating each key at most once.
+// Dependencies of an entry are kept when its value is replaced by Put.
+//
+// The cache maintains an index from keys to dependent entries, which costs
+// memory proportional to the number of dependencies of all entries. The index
+// is updated asynchronously and entries are removed from it when they are
+// evicted, expired or invalidated.
+func (c *localCache) PutWithDependencies(k Key, v Value, dependsOn ...Key) {
+	if c == nil {
+		return
+	}
+	if c.onOperation != nil {
+		defer c.observe("PutWithDependencies", c.now())
+	}
+	en := c.put(k, c.transform(v))
+	if en != nil && atomic.LoadInt32(&c.closing) == 0 {
+		if len(dependsOn) > 0 {
+			atomic.StoreInt32(&c.hasDependencies, 1)
+		}
+		c.enqueue(entryEvent{event: eventCall, fn: func() {
+			c.setDependencies(en, dependsOn)
+		}})
+	}
+}
+
+// invalidateDependents discards all entries depending on k, directly or not,
+// if there are any.
+func (c *localCache) invalidateDependents(k Key) {
+	if atomic.LoadInt32(&c.hasDependencies) == 0 || atomic.LoadInt32(&c.closing) != 0 {
+		return
+	}
+	c.enqueue(entryEvent{event: eventCall, fn: func() {
+		c.cascade(k)
+	}})
+}
+
+// cascade removes entries depending on k transitively.
+// This function must only be called from processEntries goroutine.
+func (c *localCache) cascade(k Key) {
+	if len(c.dependents) == 0 {
+		return
+	}
+	visited := map[Key]struct{}{k: {}}
+	keys := []Key{k}
+	for len(keys) > 0 {
+		k, keys = keys[0], keys[1:]
+		var dependents []*entry
+		for en := range c.dependents[k] {
+			dependents = append(dependents, en)
+		}
+		for _, en := range dependents {
+			en.setInvalidated(true)
+			c.remove(en)
+			if _, ok := visited[en.key]; !ok {
+				visited[en.key] = struct{}{}
+				keys = append(keys, en.key)
+			}
+		}
+	}
+}
+
+// setDependencies replaces dependencies of the entry in the dependency index.
+// This function must only be called from processEntries goroutine.
+func (c *localCache) setDependencies(en *entry, keys []Key) {
+	if en.accessList == nil {
+		// The entry has been removed.
+		return
+	}
+	c.undepend(en)
+	if len(keys) == 0 {
+		return
+	}
+	if c.dependents == nil {
+		c.dependents = make(map[Key]map[*entry]struct{})
+	}
+	for _, k := range keys {
+		m := c.dependents[k]
+		if m == nil {
+			m = make(map[*entry]struct{})
+			c.dependents[k] = m
+		}
+		m[en] = struct{}{}
+	}
+	en.dependsOn = keys
+}
+
+// undepend removes the entry from the dependency index.
+// This function must only be called from processEntries goroutine.
+func (c *localCache) undepend(en *entry) {
+	for _, k := range en.dependsOn {
+		m := c.dependents[k]
+		delete(m, en)
+		if len(m) == 0 {
+			delete(c.dependents, k)
+		}
+	}
+	en.dependsOn = nil
+}
@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"testing"
+)
+
+func TestDependencies(t *testing.T) {
+	c := New(WithMaximumSize(4), WithPolicy("lru")).(*localCache)
+	defer c.Close()
+
+	c.Put(1, 1)
+	c.PutWithDependencies(2, 2, 1)
+	c.PutWithDependencies(3, 3, 2, 4)
+	// Cycle between 4 and 3.
+	c.PutWithDependencies(4, 4, 3)
+	c.call(func() {})
+
+	c.Invalidate(1)
+	c.call(func() {})
+	for i := 1; i <= 4; i++ {
+		if _, ok := c.GetIfPresent(i); ok {
+			t.Fatalf("expect %d not present", i)
+		}
+	}
+	c.call(func() {
+		if len(c.dependents) != 0 {
+			t.Errorf("unexpected dependents: %v", c.dependents)
+		}
+	})
+
+	// Absent keys can be depended on.
+	c.PutWithDependencies(5, 5, 6)
+	c.PutWithDependencies(7, 7, 8)
+	c.call(func() {})
+	c.Invalidate(6)
+	c.call(func() {})
+	if _, ok := c.GetIfPresent(5); ok {
+		t.Fatalf("expect not present")
+	}
+	if _, ok := c.GetIfPresent(7); !ok {
+		t.Fatalf("expect present")
+	}
+
+	// Evicted entries are removed from the index.
+	for i := 10; i < 14; i++ {
+		c.Put(i, i)
+	}
+	c.call(func() {
+		if len(c.dependents) != 0 {
+			t.Errorf("unexpected dependents: %v", c.dependents)
+		}
+	})
+}
+
+func TestShardedDependencies(t *testing.T) {
+	c := NewConsistentSharded(4, 16)
+	defer c.Close()
+	for i := 1; i < 20; i++ {
+		c.PutWithDependencies(i, i, 0)
+	}
+	c.Put(0, 0)
+	for _, s := range c.(*shardedCache).shards {
+		s.call(func() {})
+	}
+	c.Invalidate(0)
+	for _, s := range c.(*shardedCache).shards {
+		s.call(func() {})
+	}
+	for i := 0; i < 20; i++ {
+		if _, ok := c.GetIfPresent(i); ok {
+			t.Fatalf("expect %d not present", i)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io"
+)
+
+// dumpVersion is the version of dump format written by DumpTo.
+const dumpVersion = 1
+
+// dumpHeader is the first record of a dump.
+type dumpHeader struct {
+	Version int
+}
+
+// dumpEntry is a cache entry record in a dump. Key and Value are encoded
+// separately so that an entry which can not be decoded can be skipped.
+type dumpEntry struct {
+	Key        []byte
+	Value      []byte
+	AccessTime int64
+	WriteTime  int64
+}
+
+// DumpTo writes all live entries in gob format to w.
+// Keys and values must be encodable by encoding/gob, and their concrete types
+// other than the basic ones must be registered with gob.Register.
+func (c *localCache) DumpTo(w io.Writer) error {
+	if c == nil {
+		return nil
+	}
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(&dumpHeader{Version: dumpVersion}); err != nil {
+		return err
+	}
+	return c.dumpEntries(enc)
+}
+
+// dumpEntries writes all live entries to enc.
+func (c *localCache) dumpEntries(enc *gob.Encoder) error {
+	now := c.now()
+	var err error
+	c.cache.walk(func(en *entry) {
+		if err != nil || c.isExpired(en, now) {
+			return
+		}
+		var d dumpEntry
+		d.Key, err = gobEncode(en.key)
+		if err != nil {
+			return
+		}
+		d.Value, err = gobEncode(c.valueOf(en))
+		if err != nil {
+			return
+		}
+		d.AccessTime = en.getAccessTime()
+		d.WriteTime = en.getWriteTime()
+		err = enc.Encode(&d)
+	})
+	return err
+}
+
+// RestoreFrom reads entries written by DumpTo from r and adds them to the cache.
+// Access and write time of the entries are preserved, so restored entries are
+// expired on their original schedule even if they are not read.
+// Entries which keys or values can not be decoded are skipped.
+func (c *localCache) RestoreFrom(r io.Reader) error {
+	if c == nil {
+		return nil
+	}
+	return readDump(r, c.hash, func(en *entry) {
+		en.setValue(c.compress(en.getValue()))
+		c.setEntryChecksum(en)
+		c.sendEvent(eventWrite, en)
+	})
+}
+
+// readDump decodes entries written by DumpTo and calls fn for each of them.
+func readDump(r io.Reader, hash func(Key) uint64, fn func(*entry)) error {
+	dec := gob.NewDecoder(r)
+	var h dumpHeader
+	if err := dec.Decode(&h); err != nil {
+		return err
+	}
+	if h.Version != dumpVersion {
+		return errors.New("cache: unsupported dump version")
+	}
+	for {
+		var d dumpEntry
+		err := dec.Decode(&d)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		k, err := gobDecode(d.Key)
+		if err != nil {
+			continue
+		}
+		v, err := gobDecode(d.Value)
+		if err != nil {
+			continue
+		}
+		en := newEntry(k, v, hash(k))
+		en.setAccessTime(d.AccessTime)
+		en.setWriteTime(d.WriteTime)
+		fn(en)
+	}
+}
+
+func gobEncode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gobDecode(b []byte) (interface{}, error) {
+	var v interface{}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDumpRestore(t *testing.T) {
+	wg := sync.WaitGroup{}
+	insFunc := func(Key, Value) {
+		wg.Done()
+	}
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	c := New(WithExpireAfterWrite(time.Minute), WithInsertionListener(insFunc))
+	defer c.Close()
+
+	wg.Add(2)
+	c.Put(1, "a")
+	c.Put("b", 2)
+	wg.Wait()
+
+	var buf bytes.Buffer
+	if err := c.DumpTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	mockTime.add(30 * time.Second)
+
+	r := New(WithExpireAfterWrite(time.Minute), WithInsertionListener(insFunc)).(*localCache)
+	defer r.Close()
+	wg.Add(2)
+	if err := r.RestoreFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	v, ok := r.GetIfPresent(1)
+	if !ok || v.(string) != "a" {
+		t.Fatalf("unexpected get: %v %v", v, ok)
+	}
+	v, ok = r.GetIfPresent("b")
+	if !ok || v.(int) != 2 {
+		t.Fatalf("unexpected get: %v %v", v, ok)
+	}
+	// Write time is preserved.
+	mockTime.add(30*time.Second + time.Nanosecond)
+	v, ok = r.GetIfPresent(1)
+	if ok {
+		t.Fatalf("expect expired, actual: %v %v", v, ok)
+	}
+}
+
+func TestRestoreExpireUnread(t *testing.T) {
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	c := New()
+	c.Put(1, 1)
+	c.(*localCache).call(func() {})
+	var buf bytes.Buffer
+	if err := c.DumpTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	mockTime.add(30 * time.Second)
+
+	var removed []Key
+	r := New(WithExpireAfterWrite(time.Minute), WithRemovalListener(func(k Key, v Value) {
+		removed = append(removed, k)
+	})).(*localCache)
+	defer r.Close()
+	r.Put(2, 2)
+	if err := r.RestoreFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	r.call(func() {})
+	mockTime.add(30*time.Second + time.Nanosecond)
+	// The restored entry is swept by the clean up after the next write
+	// although it is behind a fresh one.
+	r.Put(3, 3)
+	r.call(func() {})
+	if len(removed) != 1 || removed[0] != 1 {
+		t.Fatalf("unexpected removed entries: %v", removed)
+	}
+}
+
+func TestRestoreSkipUndecodable(t *testing.T) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	enc.Encode(&dumpHeader{Version: dumpVersion})
+	k, _ := gobEncode(1)
+	enc.Encode(&dumpEntry{Key: k, Value: []byte("invalid")})
+	k, _ = gobEncode(2)
+	v, _ := gobEncode(2)
+	enc.Encode(&dumpEntry{Key: k, Value: v})
+
+	wg := sync.WaitGroup{}
+	c := New(WithInsertionListener(func(Key, Value) {
+		wg.Done()
+	}))
+	defer c.Close()
+	wg.Add(1)
+	if err := c.RestoreFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if v, ok := c.GetIfPresent(1); ok {
+		t.Fatalf("unexpected get: %v %v", v, ok)
+	}
+	if v, ok := c.GetIfPresent(2); !ok || v.(int) != 2 {
+		t.Fatalf("unexpected get: %v %v", v, ok)
+	}
+}
@@ -0,0 +1,113 @@
+package cache
+
+import "time"
+
+// EntryView is an immutable snapshot of a cache entry and its metadata.
+type EntryView struct {
+	key        Key
+	value      Value
+	accessTime time.Time
+	writeTime  time.Time
+	loading    bool
+	expiresAt  time.Time
+	meta       interface{}
+}
+
+// Key returns key of the entry.
+func (e *EntryView) Key() Key {
+	return e.key
+}
+
+// Value returns value of the entry.
+func (e *EntryView) Value() Value {
+	return e.value
+}
+
+// AccessTime returns the last time the entry was accessed.
+// It is the zero time unless the cache has expireAfterAccess.
+func (e *EntryView) AccessTime() time.Time {
+	return e.accessTime
+}
+
+// WriteTime returns the last time the entry was written.
+func (e *EntryView) WriteTime() time.Time {
+	return e.writeTime
+}
+
+// Meta returns metadata of the entry given by PutWithMeta, or nil.
+func (e *EntryView) Meta() interface{} {
+	return e.meta
+}
+
+// IsLoading returns whether the entry was being refreshed.
+func (e *EntryView) IsLoading() bool {
+	return e.loading
+}
+
+// ExpiresAt returns the time the entry expires, which is the zero time
+// if the cache has no expiration.
+func (e *EntryView) ExpiresAt() time.Time {
+	return e.expiresAt
+}
+
+// GetEntry returns a snapshot of the entry associated with k, or (nil, false)
+// if there is no cached value for k. It is a read like GetIfPresent, so the
+// access time of the entry is updated and stats are recorded.
+func (c *localCache) GetEntry(k Key) (*EntryView, bool) {
+	if c == nil {
+		return nil, false
+	}
+	if c.onOperation != nil {
+		defer c.observe("GetEntry", c.now())
+	}
+	en := c.cache.get(k, c.hash(k))
+	now := c.now()
+	if en == nil {
+		c.recordMiss(nil, now)
+		return nil, false
+	}
+	if c.isExpired(en, now) {
+		c.recordMiss(en, now)
+		c.sendEvent(eventDelete, en)
+		return nil, false
+	}
+	if c.isCorrupted(en) {
+		c.recordCorruptedMiss()
+		c.discardCorrupted(en)
+		return nil, false
+	}
+	c.stats.RecordHits(1)
+	c.setEntryAccessTime(en, now)
+	c.sendEvent(eventAccess, en)
+	return c.viewOf(en), true
+}
+
+// viewOf returns a snapshot of the entry.
+func (c *localCache) viewOf(en *entry) *EntryView {
+	v := &EntryView{
+		key:        en.key,
+		value:      c.valueOf(en),
+		accessTime: unixTime(en.getAccessTime()),
+		writeTime:  unixTime(en.getWriteTime()),
+		loading:    en.getLoading(),
+		meta:       en.getMeta(),
+	}
+	if c.expireAfterAccess > 0 {
+		v.expiresAt = v.accessTime.Add(c.expireAfterAccess)
+	}
+	if c.expireAfterWrite > 0 {
+		t := v.writeTime.Add(c.expireAfterWrite)
+		if v.expiresAt.IsZero() || t.Before(v.expiresAt) {
+			v.expiresAt = t
+		}
+	}
+	return v
+}
+
+// unixTime converts nanoseconds to time, keeping 0 as the zero time.
+func unixTime(nsec int64) time.Time {
+	if nsec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nsec)
+}
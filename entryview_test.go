@@ -0,0 +1,39 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetEntry(t *testing.T) {
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	c := New(WithExpireAfterAccess(2*time.Second), WithExpireAfterWrite(3*time.Second))
+	defer c.Close()
+
+	if _, ok := c.GetEntry(1); ok {
+		t.Fatalf("entry must not be present")
+	}
+	written := mockTime.now()
+	c.Put(1, "a")
+	mockTime.add(1500 * time.Millisecond)
+	e, ok := c.GetEntry(1)
+	if !ok || e.Key() != 1 || e.Value() != "a" || e.IsLoading() {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+	if !e.WriteTime().Equal(written) || !e.AccessTime().Equal(mockTime.now()) {
+		t.Fatalf("unexpected times: %v %v", e.WriteTime(), e.AccessTime())
+	}
+	// Write expiry is earlier than access expiry.
+	if !e.ExpiresAt().Equal(written.Add(3 * time.Second)) {
+		t.Fatalf("unexpected expiry: %v", e.ExpiresAt())
+	}
+	// The snapshot is not changed by later updates.
+	c.Put(1, "b")
+	if e.Value() != "a" {
+		t.Fatalf("unexpected value: %v", e.Value())
+	}
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"sync"
+)
+
+// workerPool is an Executor running tasks in a fixed number of go routines.
+// Tasks are queued when all workers are busy.
+type workerPool struct {
+	mu     sync.Mutex
+	cond   sync.Cond
+	tasks  []func()
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// newWorkerPool starts the given number of workers.
+func newWorkerPool(workers int) *workerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &workerPool{}
+	p.cond.L = &p.mu
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// Execute queues fn to be run by a worker. It never blocks.
+func (p *workerPool) Execute(fn func()) {
+	p.mu.Lock()
+	if !p.closed {
+		p.tasks = append(p.tasks, fn)
+		p.cond.Signal()
+	}
+	p.mu.Unlock()
+}
+
+// Close discards queued tasks and waits for running ones to finish.
+func (p *workerPool) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.tasks = nil
+	p.cond.Broadcast()
+	p.mu.Unlock()
+	p.wg.Wait()
+	return nil
+}
+
+func (p *workerPool) work() {
+	defer p.wg.Done()
+	for {
+		p.mu.Lock()
+		for len(p.tasks) == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if p.closed {
+			p.mu.Unlock()
+			return
+		}
+		fn := p.tasks[0]
+		p.tasks[0] = nil
+		p.tasks = p.tasks[1:]
+		p.mu.Unlock()
+		fn()
+	}
+}
@@ -0,0 +1,185 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool(t *testing.T) {
+	p := newWorkerPool(2)
+	var running, maxRunning, done int32
+	wg := sync.WaitGroup{}
+	wg.Add(10)
+	for i := 0; i < 10; i++ {
+		p.Execute(func() {
+			defer wg.Done()
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			atomic.AddInt32(&done, 1)
+		})
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&maxRunning); n > 2 {
+		t.Fatalf("unexpected concurrent tasks: %d", n)
+	}
+	p.Close()
+	p.Execute(func() {
+		atomic.AddInt32(&done, 1)
+	})
+	time.Sleep(time.Millisecond)
+	if n := atomic.LoadInt32(&done); n != 10 {
+		t.Fatalf("unexpected done tasks: %d", n)
+	}
+}
+
+func TestRefreshWorkers(t *testing.T) {
+	wg := sync.WaitGroup{}
+	insFunc := func(Key, Value) {
+		wg.Done()
+	}
+	c := NewLoadingCache(simpleLoader, WithRefreshWorkers(1), WithInsertionListener(insFunc))
+	defer c.Close()
+
+	wg.Add(2)
+	c.Put(1, 0)
+	c.Refresh(1)
+	wg.Wait()
+	if v, _ := c.GetIfPresent(1); v != 1 {
+		t.Fatalf("unexpected value: %v", v)
+	}
+}
+
+func TestSharedExecutor(t *testing.T) {
+	p := newWorkerPool(2)
+	defer p.Close()
+	var running, maxRunning, loads int32
+	loader := func(k Key) (Value, error) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		atomic.AddInt32(&loads, 1)
+		return k, nil
+	}
+	caches := make([]*localCache, 3)
+	for i := range caches {
+		caches[i] = NewLoadingCache(loader, WithSharedExecutor(p)).(*localCache)
+		for k := 0; k < 4; k++ {
+			caches[i].Put(k, 0)
+		}
+	}
+	for _, c := range caches {
+		for k := 0; k < 4; k++ {
+			c.Refresh(k)
+		}
+	}
+	for _, c := range caches {
+		c.WaitForLoads()
+	}
+	if n := atomic.LoadInt32(&maxRunning); n > 2 {
+		t.Fatalf("unexpected concurrent loads: %d", n)
+	}
+	if n := atomic.LoadInt32(&loads); n != 12 {
+		t.Fatalf("unexpected loads: %d", n)
+	}
+	// Closing a cache does not close the shared executor.
+	caches[0].Close()
+	caches[1].Refresh(0)
+	caches[1].WaitForLoads()
+	if n := atomic.LoadInt32(&loads); n != 13 {
+		t.Fatalf("unexpected loads: %d", n)
+	}
+	caches[1].Close()
+	caches[2].Close()
+}
+
+type closeCountingExecutor struct {
+	closed int32
+}
+
+func (e *closeCountingExecutor) Execute(fn func()) {
+	go fn()
+}
+
+func (e *closeCountingExecutor) Close() error {
+	atomic.AddInt32(&e.closed, 1)
+	return nil
+}
+
+func TestExecutorClose(t *testing.T) {
+	owned := &closeCountingExecutor{}
+	shared := &closeCountingExecutor{}
+	NewLoadingCache(simpleLoader, WithExecutor(owned)).Close()
+	NewLoadingCache(simpleLoader, WithSharedExecutor(shared)).Close()
+	if n := atomic.LoadInt32(&owned.closed); n != 1 {
+		t.Fatalf("expected executor closed once, actual: %d", n)
+	}
+	if n := atomic.LoadInt32(&shared.closed); n != 0 {
+		t.Fatalf("expected shared executor not closed, actual: %d", n)
+	}
+}
+
+func TestRefreshWorkersClose(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	loader := func(k Key) (Value, error) {
+		if k == 1 {
+			started <- struct{}{}
+			<-release
+		}
+		return k, nil
+	}
+	c := NewLoadingCache(loader, WithRefreshWorkers(1))
+	p := c.(*localCache).exec.(*workerPool)
+	c.Put(1, 0)
+	c.Put(2, 0)
+	c.Refresh(1)
+	<-started
+	// Refresh of key 2 is queued as the only worker is busy.
+	c.Refresh(2)
+	closed := make(chan struct{})
+	go func() {
+		c.Close()
+		close(closed)
+	}()
+	// Wait for the queued refresh to be discarded.
+	for {
+		p.mu.Lock()
+		discarded := p.closed
+		p.mu.Unlock()
+		if discarded {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not return")
+	}
+	if keys := c.LoadingKeys(); len(keys) != 0 {
+		t.Fatalf("unexpected loading keys: %v", keys)
+	}
+	lc := c.(*localCache)
+	lc.loadMu.Lock()
+	running := lc.running
+	lc.loadMu.Unlock()
+	if running != 0 {
+		t.Fatalf("unexpected running loads: %d", running)
+	}
+}
@@ -0,0 +1,40 @@
+package cache
+
+import "expvar"
+
+// PublishExpvar publishes stats of the cache as an expvar variable with
+// the given name. The variable is a map of hits, misses, evictions and size,
+// which are read when the variable is. Like expvar.Publish, it panics if
+// the name is already registered.
+func (c *localCache) PublishExpvar(name string) {
+	if c == nil {
+		return
+	}
+	publishExpvar(name, c, func() int {
+		return c.cache.len()
+	})
+}
+
+// PublishExpvar publishes total stats of all shards as an expvar variable.
+func (c *shardedCache) PublishExpvar(name string) {
+	publishExpvar(name, c, func() int {
+		n := 0
+		for _, s := range c.shards {
+			n += s.cache.len()
+		}
+		return n
+	})
+}
+
+func publishExpvar(name string, c Cache, size func() int) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		var st Stats
+		c.Stats(&st)
+		return map[string]interface{}{
+			"hits":      st.HitCount,
+			"misses":    st.MissCount,
+			"evictions": st.EvictionCount,
+			"size":      size(),
+		}
+	}))
+}
@@ -0,0 +1,26 @@
+package cache
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+)
+
+func TestPublishExpvar(t *testing.T) {
+	c := New()
+	defer c.Close()
+	c.PublishExpvar("cache_test")
+
+	c.Put(1, 1)
+	c.(*localCache).call(func() {})
+	c.GetIfPresent(1)
+	c.GetIfPresent(2)
+
+	var m map[string]uint64
+	if err := json.Unmarshal([]byte(expvar.Get("cache_test").String()), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["hits"] != 1 || m["misses"] != 1 || m["evictions"] != 0 || m["size"] != 1 {
+		t.Fatalf("unexpected stats: %v", m)
+	}
+}
@@ -1,6 +1,8 @@
 package cache
 
 import (
+	"crypto/rand"
+	"encoding/binary"
 	"math"
 	"reflect"
 )
@@ -102,10 +104,42 @@ func hashU32(v uint32) uint64 {
 	return h
 }
 
+// sumSeed calculates hash value of the given key with the seed, so that
+// collisions of keys depend on the seed. Seed 0 is the same as sum.
+func sumSeed(k interface{}, seed uint64) uint64 {
+	if seed == 0 {
+		return sum(k)
+	}
+	if s, ok := k.(string); ok {
+		return hashStringSeed(s, seed)
+	}
+	return mix64(sum(k) ^ seed)
+}
+
+// randomSeed returns a non-zero seed from a cryptographically secure source,
+// so that it cannot be predicted from other random values of the process.
+func randomSeed() uint64 {
+	var b [8]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			panic("cache: cannot generate hash seed: " + err.Error())
+		}
+		if seed := binary.LittleEndian.Uint64(b[:]); seed != 0 {
+			return seed
+		}
+	}
+}
+
 // hashString calculates hash value using FNV-1a algorithm.
 func hashString(data string) uint64 {
+	return hashStringSeed(data, 0)
+}
+
+// hashStringSeed calculates hash value using FNV-1a algorithm, starting from
+// the offset basis mixed with the seed.
+func hashStringSeed(data string, seed uint64) uint64 {
 	// Inline code from hash/fnv to reduce memory allocations
-	h := fnvOffset
+	h := fnvOffset ^ seed
 	for _, b := range data {
 		h ^= uint64(b)
 		h *= fnvPrime
@@ -79,3 +79,41 @@ func BenchmarkSumString(b *testing.B) {
 		}
 	})
 }
+
+func TestSumSeed(t *testing.T) {
+	for _, k := range []interface{}{1, "a", 1.5} {
+		if sumSeed(k, 0) != sum(k) {
+			t.Fatalf("unexpected hash of %v without seed", k)
+		}
+		if sumSeed(k, 1) == sumSeed(k, 2) {
+			t.Fatalf("expect hash of %v depends on seed", k)
+		}
+		if sumSeed(k, 1) != sumSeed(k, 1) {
+			t.Fatalf("expect hash of %v is deterministic", k)
+		}
+	}
+}
+
+func TestHashSeed(t *testing.T) {
+	c := New(WithRandomHashSeed())
+	defer c.Close()
+	if c.(*localCache).hashSeed == 0 {
+		t.Fatal("expect random hash seed")
+	}
+	s := NewConsistentSharded(4, 16, WithHashSeed(42))
+	defer s.Close()
+	for _, c := range []Cache{c, s} {
+		for i := 0; i < 10; i++ {
+			c.Put(i, i)
+			c.Put(string(rune('a'+i)), i)
+		}
+		for i := 0; i < 10; i++ {
+			if v, ok := c.GetIfPresent(i); !ok || v != i {
+				t.Fatalf("unexpected value of %d: %v", i, v)
+			}
+			if v, ok := c.GetIfPresent(string(rune('a' + i))); !ok || v != i {
+				t.Fatalf("unexpected value of %d: %v", i, v)
+			}
+		}
+	}
+}
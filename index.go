@@ -0,0 +1,107 @@
+package cache
+
+// secondaryIndex maps an attribute extracted from values to their entries.
+type secondaryIndex struct {
+	name    string
+	extract func(Value) string
+	// entries is guarded by localCache.indexMu.
+	entries map[string]*entry
+}
+
+// GetBySecondary returns value which attribute extracted by the secondary
+// index of the given name equals attr, or (nil, false) if there is no such
+// cached value. It records stats like GetIfPresent.
+//
+// The index is updated asynchronously, so a value which has just been put may
+// not be found yet, but a value found always has the given attribute.
+func (c *localCache) GetBySecondary(name, attr string) (Value, bool) {
+	if c == nil {
+		return nil, false
+	}
+	if c.onOperation != nil {
+		defer c.observe("GetBySecondary", c.now())
+	}
+	idx, en := c.lookupSecondary(name, attr)
+	now := c.now()
+	if en == nil {
+		c.recordMiss(nil, now)
+		return nil, false
+	}
+	if c.isExpired(en, now) {
+		c.recordMiss(en, now)
+		c.sendEvent(eventDelete, en)
+		return nil, false
+	}
+	if c.isCorrupted(en) {
+		c.recordCorruptedMiss()
+		c.discardCorrupted(en)
+		return nil, false
+	}
+	v := c.valueOf(en)
+	if idx.extract(v) != attr {
+		// The value has been replaced but not yet reindexed.
+		c.recordMiss(nil, now)
+		return nil, false
+	}
+	c.stats.RecordHits(1)
+	c.setEntryAccessTime(en, now)
+	c.sendEvent(eventAccess, en)
+	return v, true
+}
+
+// lookupSecondary returns the secondary index of the name and the entry of
+// attr in it, if any.
+func (c *localCache) lookupSecondary(name, attr string) (*secondaryIndex, *entry) {
+	c.indexMu.RLock()
+	defer c.indexMu.RUnlock()
+	for _, idx := range c.indexes {
+		if idx.name == name {
+			return idx, idx.entries[attr]
+		}
+	}
+	return nil, nil
+}
+
+// reindex updates attributes of the entry in the secondary indexes.
+// This function must only be called from processEntries goroutine.
+func (c *localCache) reindex(en *entry) {
+	// The entry may have been merged into an existing one.
+	en = c.cache.get(en.key, en.hash)
+	if en == nil || en.accessList == nil {
+		return
+	}
+	v := c.valueOf(en)
+	c.indexMu.Lock()
+	if en.indexed == nil {
+		en.indexed = make([]string, len(c.indexes))
+	}
+	for i, idx := range c.indexes {
+		attr := idx.extract(v)
+		old := en.indexed[i]
+		if old == attr && idx.entries[attr] == en {
+			continue
+		}
+		if idx.entries[old] == en {
+			delete(idx.entries, old)
+		}
+		idx.entries[attr] = en
+		en.indexed[i] = attr
+	}
+	c.indexMu.Unlock()
+}
+
+// unindex removes the entry from the secondary indexes.
+// This function must only be called from processEntries goroutine.
+func (c *localCache) unindex(en *entry) {
+	if en.indexed == nil {
+		return
+	}
+	c.indexMu.Lock()
+	for i, idx := range c.indexes {
+		if attr := en.indexed[i]; idx.entries[attr] == en {
+			delete(idx.entries, attr)
+		}
+	}
+	c.indexMu.Unlock()
+	en.indexed = nil
+}
@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"testing"
+)
+
+type testUser struct {
+	id    int
+	email string
+}
+
+func TestSecondaryIndex(t *testing.T) {
+	c := New(WithMaximumSize(2), WithPolicy("lru"), WithSecondaryIndex("email", func(v Value) string {
+		return v.(*testUser).email
+	}))
+	defer c.Close()
+	l := c.(*localCache)
+
+	c.Put(1, &testUser{1, "a@example.com"})
+	c.Put(2, &testUser{2, "b@example.com"})
+	l.call(func() {})
+	v, ok := c.GetBySecondary("email", "b@example.com")
+	if !ok || v.(*testUser).id != 2 {
+		t.Fatalf("unexpected value: %v %v", v, ok)
+	}
+	if _, ok = c.GetBySecondary("name", "b@example.com"); ok {
+		t.Fatalf("expect not present")
+	}
+	// Changed attribute is reindexed.
+	c.Put(1, &testUser{1, "c@example.com"})
+	l.call(func() {})
+	if _, ok = c.GetBySecondary("email", "a@example.com"); ok {
+		t.Fatalf("expect not present")
+	}
+	if v, ok = c.GetBySecondary("email", "c@example.com"); !ok || v.(*testUser).id != 1 {
+		t.Fatalf("unexpected value: %v %v", v, ok)
+	}
+	// Evicted and invalidated entries are removed from the index.
+	c.Put(3, &testUser{3, "d@example.com"})
+	c.Invalidate(1)
+	l.call(func() {})
+	for _, email := range []string{"b@example.com", "c@example.com"} {
+		if _, ok = c.GetBySecondary("email", email); ok {
+			t.Fatalf("expect %s not present", email)
+		}
+	}
+	l.call(func() {
+		if n := len(l.indexes[0].entries); n != 1 {
+			t.Errorf("unexpected index size: %d", n)
+		}
+	})
+	var st Stats
+	c.Stats(&st)
+	if st.HitCount != 2 || st.MissCount != 4 {
+		t.Fatalf("unexpected stats: %v", &st)
+	}
+}
+
+func TestSecondaryIndexUpdate(t *testing.T) {
+	c := New(WithSecondaryIndex("email", func(v Value) string {
+		return v.(*testUser).email
+	}))
+	defer c.Close()
+	l := c.(*localCache)
+
+	c.Put(1, &testUser{1, "a@example.com"})
+	l.call(func() {})
+	if !c.Update(1, &testUser{1, "b@example.com"}) {
+		t.Fatalf("expect updated")
+	}
+	l.call(func() {})
+	if _, ok := c.GetBySecondary("email", "a@example.com"); ok {
+		t.Fatalf("expect not present")
+	}
+	if v, ok := c.GetBySecondary("email", "b@example.com"); !ok || v.(*testUser).id != 1 {
+		t.Fatalf("unexpected value: %v %v", v, ok)
+	}
+}
@@ -0,0 +1,195 @@
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+	"sort"
+)
+
+// lfuItem is an entry in the frequency heap.
+type lfuItem struct {
+	en   *entry
+	freq uint64
+	// seq is the access sequence used to break ties, so the least recently
+	// used entry is evicted among entries with the same frequency.
+	seq   uint64
+	index int
+}
+
+// lfuHeap implements heap.Interface ordered by frequency, then by access order.
+type lfuHeap []*lfuItem
+
+func (h lfuHeap) Len() int {
+	return len(h)
+}
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].freq == h[j].freq {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].freq < h[j].freq
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap) Push(x interface{}) {
+	item := x.(*lfuItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*h = old[:n-1]
+	return item
+}
+
+// lfuCache evicts the least frequently used entry, counting exact number of
+// accesses of each entry. Entries are kept in a binary heap, so that write and
+// access take O(log n).
+// Entries are also kept in a list ordered by access time for expiration.
+type lfuCache struct {
+	cache *cache
+	cap   int
+
+	heap  lfuHeap
+	items map[*entry]*lfuItem
+	seq   uint64
+	ls    list.List
+}
+
+// init initializes the frequency heap.
+func (l *lfuCache) init(c *cache, cap int) {
+	l.cache = c
+	l.cap = cap
+	l.heap = nil
+	l.items = make(map[*entry]*lfuItem)
+	l.ls.Init()
+}
+
+// write adds new entry to the cache and returns evicted entry if necessary.
+// The victim is chosen among the existing entries, so a new entry is only
+// evicted by its own write when all of them are pinned.
+func (l *lfuCache) write(en *entry) *entry {
+	// Fast path
+	if en.accessList != nil {
+		// Entry existed, update its frequency instead.
+		l.markAccess(en)
+		return nil
+	}
+	cen := l.cache.getOrSet(en)
+	if cen != nil {
+		// Entry has already been added, update its value instead.
+		cen.copyValue(en)
+		cen.setWriteTime(en.getWriteTime())
+		if cen.accessList != nil {
+			l.markAccess(cen)
+			return nil
+		}
+		// Entry is loaded to the cache but not yet registered.
+		en = cen
+	}
+	var ren *entry
+	if l.cap > 0 && l.heap.Len() >= l.cap {
+		// Remove the least frequently used entry when capacity exceeded.
+		if ren = l.victim(); ren == nil {
+			// All entries are pinned, evict the new one.
+			l.cache.delete(en)
+			return en
+		}
+		ren = l.remove(ren)
+	}
+	l.push(en)
+	return ren
+}
+
+// victim returns the unpinned entry with the lowest frequency.
+func (l *lfuCache) victim() *entry {
+	if len(l.heap) == 0 {
+		return nil
+	}
+	if !l.heap[0].en.getPinned() {
+		return l.heap[0].en
+	}
+	// Slow path: find the lowest one among unpinned entries.
+	min := -1
+	for i, item := range l.heap {
+		if !item.en.getPinned() && (min < 0 || l.heap.Less(i, min)) {
+			min = i
+		}
+	}
+	if min < 0 {
+		return nil
+	}
+	return l.heap[min].en
+}
+
+// access increases frequency of the entry.
+func (l *lfuCache) access(en *entry) {
+	if en.accessList != nil {
+		l.markAccess(en)
+	}
+}
+
+// markAccess increases frequency and updates access order of the entry.
+// en.accessList must not be null.
+func (l *lfuCache) markAccess(en *entry) {
+	l.ls.MoveToFront(en.accessList)
+	item := l.items[en]
+	item.freq++
+	l.seq++
+	item.seq = l.seq
+	heap.Fix(&l.heap, item.index)
+}
+
+// remove removes an entry from the cache.
+func (l *lfuCache) remove(en *entry) *entry {
+	if en.accessList == nil {
+		// Already deleted
+		return nil
+	}
+	l.cache.delete(en)
+	l.ls.Remove(en.accessList)
+	en.accessList = nil
+	item := l.items[en]
+	delete(l.items, en)
+	heap.Remove(&l.heap, item.index)
+	return en
+}
+
+// iterate walks through all entries by access time.
+func (l *lfuCache) iterate(fn func(en *entry) bool) {
+	iterateListFromBack(&l.ls, fn)
+}
+
+// evictionOrder walks through all entries from the lowest frequency.
+func (l *lfuCache) evictionOrder(fn func(en *entry) bool) {
+	h := make(lfuHeap, len(l.heap))
+	copy(h, l.heap)
+	// sort.Slice does not call h.Swap so item indexes are unchanged.
+	sort.Slice(h, h.Less)
+	for _, item := range h {
+		if !fn(item.en) {
+			return
+		}
+	}
+}
+
+func (l *lfuCache) push(en *entry) {
+	l.seq++
+	item := &lfuItem{
+		en:  en,
+		seq: l.seq,
+	}
+	l.items[en] = item
+	heap.Push(&l.heap, item)
+	en.accessList = l.ls.PushFront(en)
+}
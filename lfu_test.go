@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"testing"
+)
+
+func TestLFU(t *testing.T) {
+	c := cache{}
+	l := lfuCache{}
+	l.init(&c, 3)
+
+	en := []*entry{
+		newEntry(1, 1, sum(1)),
+		newEntry(2, 2, sum(2)),
+		newEntry(3, 3, sum(3)),
+		newEntry(4, 4, sum(4)),
+		newEntry(5, 5, sum(5)),
+	}
+	for i := 0; i < 3; i++ {
+		if ren := l.write(en[i]); ren != nil {
+			t.Fatalf("unexpected entry removed: %v", ren.key)
+		}
+	}
+	l.access(en[0])
+	l.access(en[0])
+	l.access(en[1])
+	l.access(en[2])
+	// 1 is accessed twice, 2 and 3 once. 2 is less recently used.
+	ren := l.write(en[3])
+	if ren == nil || ren.key != 2 {
+		t.Fatalf("unexpected entry removed: %v", ren)
+	}
+	// 4 has never been accessed.
+	ren = l.write(en[4])
+	if ren == nil || ren.key != 4 {
+		t.Fatalf("unexpected entry removed: %v", ren)
+	}
+	var keys []Key
+	l.evictionOrder(func(en *entry) bool {
+		keys = append(keys, en.key)
+		return true
+	})
+	if len(keys) != 3 || keys[0] != 5 || keys[1] != 3 || keys[2] != 1 {
+		t.Fatalf("unexpected eviction order: %v", keys)
+	}
+	if n := cacheSize(&c); n != 3 {
+		t.Fatalf("unexpected cache size: %d", n)
+	}
+	// The new entry is evicted when all entries are pinned.
+	for i := range keys {
+		l.cache.get(keys[i], sum(keys[i])).setPinned(true)
+	}
+	ren = l.write(newEntry(6, 6, sum(6)))
+	if ren == nil || ren.key != 6 || ren.accessList != nil {
+		t.Fatalf("unexpected entry removed: %v", ren)
+	}
+	if n := cacheSize(&c); n != 3 || l.heap.Len() != 3 {
+		t.Fatalf("unexpected cache size: %d %d", n, l.heap.Len())
+	}
+}
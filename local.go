@@ -1,6 +1,10 @@
 package cache
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -9,17 +13,38 @@ import (
 const (
 	// Default maximum number of cache entries.
 	maximumCapacity = 1 << 30
-	// Buffer size of entry channels
+	// Default buffer size of entry channels
 	chanBufSize = 64
 	// Maximum number of entries to be drained in a single clean up.
 	drainMax = 16
+	// Maximum number of entries to be drained in a single clean up when
+	// previous clean ups could not catch up with expired entries.
+	drainMaxBurst = 1024
 	// Number of cache access operations that will trigger clean up.
 	drainThreshold = 64
+	// Maximum number of entries to be walked when estimating expired entries.
+	expiredEstimateMax = 1024
+	// Interval of adjusting adaptive cache capacity.
+	adaptiveInterval = 1 * time.Minute
+	// Hit rate under which adaptive cache capacity is increased when there are evictions.
+	adaptiveHitRate = 0.9
+	// Maximum time to wait for the cache to respond to a health check.
+	healthTimeout = 1 * time.Second
 )
 
 // currentTime is an alias for time.Now, used for testing.
 var currentTime = time.Now
 
+// clockStart is the reference point of times read by monotonicTime.
+var clockStart = time.Now()
+
+// monotonicTime returns clockStart plus the time elapsed since then, measured
+// by the monotonic clock, so that it is not affected by wall clock changes.
+// It equals currentTime when that is mocked without a monotonic reading.
+func monotonicTime() time.Time {
+	return clockStart.Add(currentTime().Sub(clockStart))
+}
+
 // localCache is an asynchronous LRU cache.
 type localCache struct {
 	// internal data structure
@@ -29,17 +54,136 @@ type localCache struct {
 	expireAfterAccess time.Duration
 	expireAfterWrite  time.Duration
 	refreshAfterWrite time.Duration
+	refreshDebounce   time.Duration
+	refreshJitter     float64
+	loadPromiseTTL    time.Duration
+	errorCacheTTL     time.Duration
+	loadWaitTimeout   time.Duration
+	refreshPredicate  func(Key, Value) bool
 	policyName        string
+	customPolicy      Policy
+	newCustomPolicy   func() Policy
+	priority          PriorityFunc
+	readThrough       bool
+
+	refreshPreservesRecency bool
+	// collapseWrites skips write events of entries which are already pending.
+	collapseWrites bool
+	// lazyExpiry disables removing expired entries in clean ups.
+	lazyExpiry bool
+	// consistencyChecks compares the policy with the entries in clean ups.
+	consistencyChecks bool
+	// uncheckedWrites is the number of writes since the last consistency check.
+	uncheckedWrites int
+	// monotonicClock reads entry times from monotonicTime.
+	monotonicClock bool
+	// writeCoalesce delays write events of updated entries.
+	writeCoalesce time.Duration
+	// nonBlockingHits drops access events when the events channel is full.
+	nonBlockingHits bool
+	compression     Compression
+	// valueTransform is applied to values before they are stored.
+	valueTransform func(Value) Value
+	// sketchSampleFactor scales the TinyLFU sample size.
+	sketchSampleFactor float64
+	// evictionSamples is the number of entries sampled by "sampled" policy.
+	evictionSamples int
 
 	onInsertion Func
 	onRemoval   Func
+	onClose     func([]Entry)
+	onError     func(Key, error)
+	// insertions queues notifications for an asynchronous insertion
+	// listener, see WithAsyncInsertionListener.
+	insertions       chan Entry
+	insertionBufSize int
+	// onInvalidate is called when a key is invalidated by Invalidate.
+	onInvalidate func(Key)
+	// onExpiryBatch is called with entries expired in a clean up.
+	onExpiryBatch func([]Entry)
+	// onReplacement is called when Put replaces a live value.
+	onReplacement func(k Key, old, new Value)
+	// onOperation is called with duration of each public operation.
+	onOperation func(string, time.Duration)
+	// onEmptyState is called when the cache becomes empty or non-empty.
+	onEmptyState func(bool)
+	// nonEmpty is the state last notified to onEmptyState.
+	// It is only accessed in processEntries goroutine.
+	nonEmpty bool
+	// onBackpressure is called when sending an event blocks for at least
+	// backpressureThreshold.
+	onBackpressure        func(string, time.Duration)
+	backpressureThreshold time.Duration
+	// bufSize is the buffer size of events channel.
+	bufSize int
+	// configErr is the first invalid option, reported by NewStrict.
+	configErr error
+	// expiredServe is how expired entries are served by loading cache.
+	expiredServe ExpiredServePolicy
+	// hashSeed is mixed into hash values of keys.
+	hashSeed uint64
 
-	loader LoaderFunc
-	exec   Executor
-	stats  StatsCounter
+	loader  LoaderFunc
+	exec    Executor
+	stats   StatsCounter
+	weigher Weigher
+	// sharedExec is set when exec is given by WithSharedExecutor, so it is
+	// not closed with the cache.
+	sharedExec bool
+	// refreshWorkers is the number of workers of the pool created by init.
+	refreshWorkers int
+	// refreshFunc is used instead of loader to refresh existing entries.
+	refreshFunc RefreshFunc
+	// loadFallback provides the value returned when loading fails.
+	loadFallback func(Key) Value
+	// recoverPanics makes loader panics returned as errors.
+	recoverPanics bool
+	// rejectNil prevents nil values from being stored.
+	rejectNil bool
+	// checksum is used to verify integrity of values.
+	checksum func(Value) uint64
+
+	// tags indexes entries by their tags.
+	// It is only accessed in processEntries goroutine.
+	tags map[string]map[*entry]struct{}
+	// dependents indexes entries by the keys they depend on.
+	// It is only accessed in processEntries goroutine.
+	dependents map[Key]map[*entry]struct{}
+	// hasDependencies is set when an entry with dependencies has been added.
+	hasDependencies int32
+	// indexes are secondary indexes of values, which are updated in
+	// processEntries goroutine.
+	indexes []*secondaryIndex
+	indexMu sync.RWMutex
+
+	// loads contains in-flight loads by key.
+	loads map[Key]*loadCall
+	// factoryCalls contains in-flight factory calls of GetOrSet by key, which
+	// are not shared with loads.
+	factoryCalls map[Key]*loadCall
+	loadMu       sync.Mutex
+	// pressure counts insertions and evictions for EvictionPressure.
+	pressure pressureCounter
+	// strictCapacity is set when new entries are only added by the policy.
+	strictCapacity int32 // Access atomically
+	// running is the number of running loads and refreshes, and idle is
+	// closed when it drops to zero. Both are guarded by loadMu.
+	running int
+	idle    chan struct{}
+	// queued contains entries which refreshes are given to the executor but
+	// not yet started, guarded by loadMu.
+	queued map[*entry]struct{}
+
+	// maxValuesPerKey limits number of values added by Append.
+	maxValuesPerKey int
+	appendMu        sync.Mutex
 
 	// cap is the cache capacity.
-	cap int
+	// It must be accessed atomically after init when adaptive size is enabled.
+	cap int32
+	// adaptiveMin and adaptiveMax are bounds of the adaptive capacity.
+	adaptiveMin int
+	adaptiveMax int
 
 	// accessQueue is the cache retention policy, which manages entries by access time.
 	accessQueue policy
@@ -51,230 +195,1319 @@ type localCache struct {
 
 	// readCount is a counter of the number of reads since the last write.
 	readCount int32
+	// drainLimit is the number of entries to be drained in the next clean up.
+	// It is only accessed in processEntries goroutine.
+	drainLimit int
 
 	// for closing routines created by this cache.
 	closing int32
-	closeWG sync.WaitGroup
+	// abandoned is set when closing timed out.
+	abandoned int32
+	closeWG   sync.WaitGroup
+	// closed is closed when processEntries returns.
+	closed chan struct{}
 }
 
 // newLocalCache returns a default localCache.
 // init must be called before this cache can be used.
 func newLocalCache() *localCache {
 	return &localCache{
-		cap:   maximumCapacity,
-		cache: cache{},
-		stats: &statsCounter{},
+		cap:          maximumCapacity,
+		cache:        cache{},
+		stats:        &statsCounter{},
+		loads:        make(map[Key]*loadCall),
+		bufSize:      chanBufSize,
+		factoryCalls: make(map[Key]*loadCall),
+		queued:       make(map[*entry]struct{}),
 	}
 }
 
 // init initializes cache replacement policy after all user configuration properties are set.
 func (c *localCache) init() {
-	c.accessQueue = newPolicy(c.policyName)
-	c.accessQueue.init(&c.cache, c.cap)
+	if c.newCustomPolicy != nil {
+		c.customPolicy = c.newCustomPolicy()
+	}
+	if c.customPolicy != nil {
+		c.accessQueue = &customPolicy{p: c.customPolicy}
+	} else {
+		c.accessQueue = newPolicy(c.policyName)
+	}
+	switch p := c.accessQueue.(type) {
+	case *priorityCache:
+		p.priority = c.priority
+	case *tinyLFU:
+		p.sampleFactor = c.sketchSampleFactor
+	case *sampledCache:
+		p.samples = c.evictionSamples
+	}
+	if c.adaptiveMax > 0 {
+		if int(c.cap) < c.adaptiveMin || c.cap == maximumCapacity {
+			c.cap = int32(c.adaptiveMin)
+		} else if int(c.cap) > c.adaptiveMax {
+			c.cap = int32(c.adaptiveMax)
+		}
+	}
+	c.accessQueue.init(&c.cache, int(c.cap))
 	if c.expireAfterWrite > 0 || c.refreshAfterWrite > 0 {
 		c.writeQueue = &recencyQueue{}
 	} else {
 		c.writeQueue = discardingQueue{}
 	}
-	c.writeQueue.init(&c.cache, c.cap)
-	c.events = make(chan entryEvent, chanBufSize)
+	c.writeQueue.init(&c.cache, int(c.cap))
+	c.events = make(chan entryEvent, c.bufSize)
+	c.closed = make(chan struct{})
+	if c.refreshWorkers > 0 {
+		c.exec = newWorkerPool(c.refreshWorkers)
+		c.sharedExec = false
+	}
+
+	if c.onInsertion != nil && c.insertionBufSize > 0 {
+		c.insertions = make(chan Entry, c.insertionBufSize)
+		go c.notifyInsertions()
+	}
 
 	c.closeWG.Add(1)
 	go c.processEntries()
+	if c.adaptiveMax > 0 {
+		go c.adaptSizePeriodically()
+	}
 }
 
 // Close implements io.Closer and always returns a nil error.
 // Caller would ensure the cache is not being used (reading and writing) before closing.
 func (c *localCache) Close() error {
+	if c == nil {
+		return nil
+	}
+	return c.CloseWithTimeout(0)
+}
+
+// CloseWithTimeout closes the cache and waits up to d for all entries to be removed.
+// If it times out, the remaining entries are dropped without calling listeners
+// and ErrCloseTimeout is returned. A listener which is already running can not
+// be interrupted. Non-positive d means waiting indefinitely.
+func (c *localCache) CloseWithTimeout(d time.Duration) error {
+	if c == nil {
+		return nil
+	}
+	var timeout <-chan time.Time
+	if d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
 	if atomic.CompareAndSwapInt32(&c.closing, 0, 1) {
 		// Do not close events channel to avoid panic when cache is still being used.
-		c.events <- entryEvent{nil, eventClose}
-		// Wait for the goroutine to close this channel
-		c.closeWG.Wait()
+		select {
+		case c.events <- entryEvent{event: eventClose}:
+		case <-timeout:
+			atomic.StoreInt32(&c.abandoned, 1)
+			// Deliver the close event once the events channel has room, so
+			// processEntries still stops and later Close calls return.
+			go func() {
+				c.events <- entryEvent{event: eventClose}
+			}()
+			return ErrCloseTimeout
+		}
+	}
+	// Wait for the goroutine to finish.
+	select {
+	case <-c.closed:
+		return nil
+	case <-timeout:
+		atomic.StoreInt32(&c.abandoned, 1)
+		return ErrCloseTimeout
 	}
-	return nil
 }
 
 // GetIfPresent gets cached value from entries list and updates
 // last access time for the entry if it is found.
 func (c *localCache) GetIfPresent(k Key) (Value, bool) {
-	en := c.cache.get(k, sum(k))
+	if c == nil {
+		return nil, false
+	}
+	if c.onOperation != nil {
+		defer c.observe("GetIfPresent", c.now())
+	}
+	if c.readThrough && c.loader != nil {
+		v, err := c.Get(k)
+		return v, err == nil
+	}
+	en := c.cache.get(k, c.hash(k))
+	now := c.now()
 	if en == nil {
-		c.stats.RecordMisses(1)
+		c.recordMiss(nil, now)
 		return nil, false
 	}
-	now := currentTime()
 	if c.isExpired(en, now) {
-		c.stats.RecordMisses(1)
+		c.recordMiss(en, now)
 		c.sendEvent(eventDelete, en)
 		return nil, false
 	}
+	if c.isCorrupted(en) {
+		c.recordCorruptedMiss()
+		c.discardCorrupted(en)
+		return nil, false
+	}
 	c.stats.RecordHits(1)
 	c.setEntryAccessTime(en, now)
 	c.sendEvent(eventAccess, en)
-	return en.getValue(), true
+	return c.valueOf(en), true
+}
+
+// TouchIfPresent updates last access time of the entry associated with k and
+// returns whether it is present. Unlike GetIfPresent, it neither returns the value
+// nor records stats.
+func (c *localCache) TouchIfPresent(k Key) bool {
+	if c == nil {
+		return false
+	}
+	if c.onOperation != nil {
+		defer c.observe("TouchIfPresent", c.now())
+	}
+	en := c.cache.get(k, c.hash(k))
+	if en == nil {
+		return false
+	}
+	now := c.now()
+	if c.isExpired(en, now) {
+		c.sendEvent(eventDelete, en)
+		return false
+	}
+	c.setEntryAccessTime(en, now)
+	c.sendEvent(eventAccess, en)
+	return true
+}
+
+// Peek returns value associated with k if it is present and not expired.
+// Unlike GetIfPresent, it has no side effects: the access time is not updated,
+// stats are not recorded, the policy is not notified and expired or corrupted
+// entries are not removed. Hence it does not influence eviction or metrics.
+func (c *localCache) Peek(k Key) (Value, bool) {
+	if c == nil {
+		return nil, false
+	}
+	en := c.cache.get(k, c.hash(k))
+	if en == nil || c.isExpired(en, c.now()) || c.isCorrupted(en) {
+		return nil, false
+	}
+	return c.valueOf(en), true
 }
 
 // Put adds new entry to entries list.
 func (c *localCache) Put(k Key, v Value) {
-	h := sum(k)
+	if c == nil {
+		return
+	}
+	if c.onOperation != nil {
+		defer c.observe("Put", c.now())
+	}
+	c.put(k, c.transform(v))
+}
+
+// Update replaces value of the live entry associated with k without changing
+// its write time, so it is still refreshed and expired on the original schedule.
+// It returns false and does nothing if k is not present.
+func (c *localCache) Update(k Key, v Value) bool {
+	if c == nil {
+		return false
+	}
+	if c.onOperation != nil {
+		defer c.observe("Update", c.now())
+	}
+	en := c.cache.get(k, c.hash(k))
+	if en == nil || c.isExpired(en, c.now()) {
+		return false
+	}
+	v = c.transform(v)
+	if c.rejectsNil(k, v) {
+		return false
+	}
+	en.setValue(c.compress(v))
+	c.setEntryChecksum(en)
+	if len(c.indexes) > 0 && atomic.LoadInt32(&c.closing) == 0 {
+		// Reindex without moving the entry in the write order.
+		c.enqueue(entryEvent{event: eventCall, fn: func() { c.reindex(en) }})
+	}
+	return true
+}
+
+// put adds or updates entry for k and returns the entry.
+func (c *localCache) put(k Key, v Value) *entry {
+	now := c.now()
+	return c.putAt(k, v, nil, now, now)
+}
+
+// PutWithTimes associates v with k like Put, but with the given write and
+// access time instead of now, so that an imported entry expires and refreshes
+// on its original schedule. Times in the future are replaced with now.
+func (c *localCache) PutWithTimes(k Key, v Value, writeTime, accessTime time.Time) {
+	if c == nil {
+		return
+	}
+	if c.onOperation != nil {
+		defer c.observe("PutWithTimes", c.now())
+	}
+	now := c.now()
+	if writeTime.After(now) {
+		writeTime = now
+	}
+	if accessTime.After(now) {
+		accessTime = now
+	}
+	c.putAt(k, c.transform(v), nil, writeTime, accessTime)
+}
+
+// putAt adds or updates entry for k with the given metadata and times and
+// returns the entry.
+// It returns nil if the value is rejected.
+func (c *localCache) putAt(k Key, v Value, meta interface{}, writeTime, accessTime time.Time) *entry {
+	if c.rejectsNil(k, v) {
+		return nil
+	}
+	h := c.hash(k)
 	en := c.cache.get(k, h)
-	now := currentTime()
+	v = c.compress(v)
 	if en == nil {
 		en = newEntry(k, v, h)
-		c.setEntryWriteTime(en, now)
-		c.setEntryAccessTime(en, now)
+		en.setMeta(meta)
+		c.setEntryChecksum(en)
+		c.setEntryWriteTime(en, writeTime)
+		c.setEntryAccessTime(en, accessTime)
 		// Add to the cache directly so the new value is available immediately.
 		// However, only do this within the cache capacity (approximately).
-		if c.cap == 0 || c.cache.len() < c.cap {
+		if cap := c.Cap(); atomic.LoadInt32(&c.strictCapacity) == 0 && (cap == 0 || c.cache.len() < cap) {
 			cen := c.cache.getOrSet(en)
 			if cen != nil {
-				cen.setValue(v)
+				cen.copyValue(en)
 				en = cen
 			}
 		}
 	} else {
+		var old Value
+		replaced := c.onReplacement != nil && !c.isExpired(en, c.now())
+		if replaced {
+			old = c.valueOf(en)
+		}
 		// Update value and send notice
 		en.setValue(v)
-		en.setWriteTime(now.UnixNano())
+		en.setMeta(meta)
+		c.setEntryChecksum(en)
+		en.setWriteTime(writeTime.UnixNano())
+		en.setStale(false)
+		c.setEntryRefreshJitter(en)
+		c.setEntryAccessTime(en, accessTime)
+		// The entry may have been invalidated and is pending deletion.
+		// Clear the flag so the deletion is skipped and the new value survives.
+		en.setInvalidated(false)
+		if replaced {
+			c.onReplacement(k, old, c.valueOf(en))
+		}
+		if c.writeCoalesce > 0 {
+			if en.setWriting(true) {
+				time.AfterFunc(c.writeCoalesce, func() {
+					c.sendEvent(eventWrite, en)
+				})
+			}
+			return en
+		}
+	}
+	if c.collapseWrites && !en.setWriting(true) {
+		// A write event of this entry is still pending and it will
+		// take the new value.
+		return en
 	}
 	c.sendEvent(eventWrite, en)
+	return en
+}
+
+// hash returns hash value of k with the hash seed of the cache.
+func (c *localCache) hash(k Key) uint64 {
+	return sumSeed(k, c.hashSeed)
+}
+
+// GetOrSet returns value associated with k if it is present. Otherwise, it calls
+// factory and stores the returned value. The factory is called at most once
+// for concurrent calls of the same key.
+func (c *localCache) GetOrSet(k Key, factory func() Value) Value {
+	if c == nil {
+		return factory()
+	}
+	if c.onOperation != nil {
+		defer c.observe("GetOrSet", c.now())
+	}
+	en := c.cache.get(k, c.hash(k))
+	now := c.now()
+	if en != nil && !c.isExpired(en, now) {
+		if !c.isCorrupted(en) {
+			c.stats.RecordHits(1)
+			c.setEntryAccessTime(en, now)
+			c.sendEvent(eventAccess, en)
+			return c.valueOf(en)
+		}
+		c.recordCorruptedMiss()
+	} else {
+		c.recordMiss(en, now)
+	}
+	v, _ := c.share(c.factoryCalls, false, k, func(Key) (Value, error) {
+		return factory(), nil
+	})
+	return v
 }
 
-// Invalidate removes the entry associated with key k.
+// Invalidate removes the entry associated with key k and passes k to
+// the invalidation broadcaster if there is one.
 func (c *localCache) Invalidate(k Key) {
-	en := c.cache.get(k, sum(k))
+	if c == nil {
+		return
+	}
+	if c.onOperation != nil {
+		defer c.observe("Invalidate", c.now())
+	}
+	c.InvalidateLocal(k)
+	if c.onInvalidate != nil {
+		c.onInvalidate(k)
+	}
+}
+
+// InvalidateLocal removes the entry associated with key k without calling
+// the invalidation broadcaster.
+func (c *localCache) InvalidateLocal(k Key) {
+	if c == nil {
+		return
+	}
+	en := c.cache.get(k, c.hash(k))
 	if en != nil {
 		en.setInvalidated(true)
 		c.sendEvent(eventDelete, en)
 	}
+	c.forgetLoad(k)
+	c.invalidateDependents(k)
+}
+
+// Clear synchronously removes all entries and resets the eviction policy and
+// internal counters, keeping the goroutine maintaining the cache, unlike
+// creating a new cache. The removal listener is only called if notify is true.
+// Stats are kept and can be reset by ResetStats.
+// It is intended for reusing a cache, e.g. between benchmark iterations.
+func (c *localCache) Clear(notify bool) {
+	if c == nil {
+		return
+	}
+	if c.onOperation != nil {
+		defer c.observe("Clear", c.now())
+	}
+	c.forgetLoads()
+	c.call(func() {
+		c.accessQueue.iterate(func(en *entry) bool {
+			if notify {
+				c.remove(en)
+			} else {
+				c.unlink(en)
+			}
+			return true
+		})
+		c.accessQueue.init(&c.cache, c.capacity())
+		c.writeQueue.init(&c.cache, c.capacity())
+		atomic.StoreInt32(&c.readCount, 0)
+		c.drainLimit = drainMax
+	})
+}
+
+// MarkStale makes the entry associated with k expired without removing it, so
+// its next Get returns the current value and reloads it asynchronously, or loads
+// it synchronously with ServeExpiredNever. It is lighter than Refresh, which
+// reloads even if the entry is not accessed again, and Invalidate, which stops
+// serving the current value. Entries marked stale are not removed by expiration
+// sweeps, but GetIfPresent treats them as expired and removes them. The mark is
+// cleared when the entry is written or refreshed.
+func (c *localCache) MarkStale(k Key) {
+	if c == nil {
+		return
+	}
+	if c.onOperation != nil {
+		defer c.observe("MarkStale", c.now())
+	}
+	if en := c.cache.get(k, c.hash(k)); en != nil {
+		en.setStale(true)
+	}
+}
+
+// Pin marks the entry associated with key k not to be evicted by the cache policy.
+func (c *localCache) Pin(k Key) {
+	if c == nil {
+		return
+	}
+	en := c.cache.get(k, c.hash(k))
+	if en != nil {
+		en.setPinned(true)
+	}
+}
+
+// Unpin allows the entry associated with key k to be evicted again.
+func (c *localCache) Unpin(k Key) {
+	if c == nil {
+		return
+	}
+	en := c.cache.get(k, c.hash(k))
+	if en != nil {
+		en.setPinned(false)
+	}
 }
 
-// InvalidateAll resets entries list.
+// InvalidateAll removes all entries and waits until they are removed, including
+// calls of the removal listener. Entries put before it is called are removed
+// and entries put after it returns are kept, even if their keys existed.
+// Entries put concurrently with it may or may not be removed.
 func (c *localCache) InvalidateAll() {
+	if c == nil {
+		return
+	}
+	if c.onOperation != nil {
+		defer c.observe("InvalidateAll", c.now())
+	}
+	c.forgetLoads()
+	// Pending writes were sent before this call, so their entries are in the
+	// access queue when it is run.
+	c.call(func() {
+		c.accessQueue.iterate(func(en *entry) bool {
+			en.setInvalidated(true)
+			c.remove(en)
+			return true
+		})
+	})
+}
+
+// Compact rebuilds the internal maps of the cache, releasing memory held for
+// removed entries as Go maps do not shrink. It copies all entries, so it costs
+// time and temporary memory proportional to the cache size and blocks other
+// operations which need the event goroutine meanwhile. It is intended to be
+// called after most entries are removed, e.g. by InvalidateAll.
+func (c *localCache) Compact() {
+	if c == nil {
+		return
+	}
+	if c.onOperation != nil {
+		defer c.observe("Compact", c.now())
+	}
+	c.call(c.cache.compact)
+}
+
+// Find returns live entries for which pred returns true, up to limit entries
+// if limit is positive. Entries are neither accessed nor counted in stats.
+// It walks entries without blocking other operations, so entries added or
+// removed concurrently may or may not be returned.
+func (c *localCache) Find(pred func(k Key, v Value) bool, limit int) map[Key]Value {
+	if c == nil {
+		return nil
+	}
+	if c.onOperation != nil {
+		defer c.observe("Find", c.now())
+	}
+	found := make(map[Key]Value)
+	now := c.now()
 	c.cache.walk(func(en *entry) {
-		en.setInvalidated(true)
+		if limit > 0 && len(found) >= limit || c.isExpired(en, now) {
+			return
+		}
+		if v := c.valueOf(en); pred(en.key, v) {
+			found[en.key] = v
+		}
+	})
+	return found
+}
+
+// Drain removes all entries from the cache and returns the live ones.
+// Removal listener is called for every removed entry. Entries written
+// concurrently with Drain may not be removed.
+func (c *localCache) Drain() map[Key]Value {
+	if c == nil {
+		return nil
+	}
+	entries := make(map[Key]Value)
+	c.call(func() {
+		now := c.now()
+		c.accessQueue.iterate(func(en *entry) bool {
+			if !c.isExpired(en, now) {
+				entries[en.key] = c.valueOf(en)
+			}
+			c.remove(en)
+			return true
+		})
 	})
-	c.sendEvent(eventDelete, nil)
+	return entries
 }
 
 // Get returns value associated with k or call underlying loader to retrieve value
 // if it is not in the cache. The returned value is only cached when loader returns
-// nil error.
+// nil error. If the cache has no loader, ErrNoLoader is returned instead of
+// loading.
 func (c *localCache) Get(k Key) (Value, error) {
-	en := c.cache.get(k, sum(k))
+	if c == nil {
+		return nil, ErrNilCache
+	}
+	if c.onOperation != nil {
+		defer c.observe("Get", c.now())
+	}
+	return c.get(k, nil)
+}
+
+// GetWithRefreshCallback returns value associated with k like Get. If the value
+// is stale and a reload is started, onRefreshed is called with the result when
+// the reload completes, in the go routine running it.
+// onRefreshed is not called if no reload is started by this call.
+func (c *localCache) GetWithRefreshCallback(k Key, onRefreshed func(Value, error)) (Value, error) {
+	if c == nil {
+		return nil, ErrNilCache
+	}
+	if c.onOperation != nil {
+		defer c.observe("GetWithRefreshCallback", c.now())
+	}
+	return c.get(k, onRefreshed)
+}
+
+// get returns value associated with k, calling done when a reload started by
+// this call completes.
+func (c *localCache) get(k Key, done func(Value, error)) (Value, error) {
+	en := c.cache.get(k, c.hash(k))
+	now := c.now()
 	if en == nil {
-		c.stats.RecordMisses(1)
+		c.recordMiss(nil, now)
+		return c.load(k)
+	}
+	if c.isCorrupted(en) {
+		c.recordCorruptedMiss()
+		c.discardCorrupted(en)
 		return c.load(k)
 	}
 	// Check if this entry needs to be refreshed
-	now := currentTime()
 	if c.isExpired(en, now) {
-		c.stats.RecordMisses(1)
+		c.recordMiss(en, now)
+		if c.expiredServe == ServeExpiredNever {
+			c.sendEvent(eventDelete, en)
+			return c.load(k)
+		}
 		if c.loader == nil {
 			c.sendEvent(eventDelete, en)
 		} else {
 			// For loading cache, we do not delete entry but leave it to
 			// the eviction policy, so users still can get the old value.
 			c.setEntryAccessTime(en, now)
-			c.refreshAsync(en)
+			c.refreshAsync(en, done)
 		}
 	} else {
 		c.stats.RecordHits(1)
 		c.setEntryAccessTime(en, now)
 		c.sendEvent(eventAccess, en)
 	}
-	return en.getValue(), nil
+	return c.valueOf(en), nil
 }
 
-// Refresh asynchronously reloads value for Key if it existed, otherwise
-// it will synchronously load and block until it value is loaded.
-func (c *localCache) Refresh(k Key) {
+// GetAndRefresh returns value associated with k and always reloads it asynchronously
+// afterward. If k is not in the cache, the value is loaded synchronously and
+// no further reload is triggered. It returns ErrNoLoader if the cache has no
+// loader.
+func (c *localCache) GetAndRefresh(k Key) (Value, error) {
+	if c == nil {
+		return nil, ErrNilCache
+	}
+	if c.onOperation != nil {
+		defer c.observe("GetAndRefresh", c.now())
+	}
 	if c.loader == nil {
-		return
+		return nil, ErrNoLoader
 	}
-	en := c.cache.get(k, sum(k))
+	en := c.cache.get(k, c.hash(k))
+	now := c.now()
 	if en == nil {
-		c.load(k)
+		c.recordMiss(nil, now)
+		return c.load(k)
+	}
+	if c.isCorrupted(en) {
+		c.recordCorruptedMiss()
+		c.discardCorrupted(en)
+		return c.load(k)
+	}
+	if c.isExpired(en, now) {
+		c.recordMiss(en, now)
+		if c.expiredServe == ServeExpiredNever {
+			c.sendEvent(eventDelete, en)
+			return c.load(k)
+		}
 	} else {
-		c.refreshAsync(en)
+		c.stats.RecordHits(1)
+		c.sendEvent(eventAccess, en)
 	}
+	c.setEntryAccessTime(en, now)
+	c.refreshAsync(en, nil)
+	return c.valueOf(en), nil
 }
 
-// Stats copies cache stats to t.
-func (c *localCache) Stats(t *Stats) {
-	c.stats.Snapshot(t)
-}
-
-func (c *localCache) processEntries() {
-	defer c.closeWG.Done()
-	for e := range c.events {
-		switch e.event {
-		case eventWrite:
-			c.write(e.entry)
-			c.postWriteCleanup()
-		case eventAccess:
-			c.access(e.entry)
-			c.postReadCleanup()
-		case eventDelete:
-			if e.entry == nil {
-				c.removeAll()
-			} else {
-				c.remove(e.entry)
-			}
-			c.postReadCleanup()
-		case eventClose:
-			if c.exec != nil {
-				// Stop all refresh tasks.
-				c.exec.Close()
-			}
-			c.removeAll()
-			return
+// GetFresh returns value associated with k if it was written no longer than
+// maxAge ago, otherwise it loads the value synchronously and stores it in the
+// cache. It allows individual reads to require recency regardless of the
+// expiration settings of the cache.
+func (c *localCache) GetFresh(k Key, maxAge time.Duration) (Value, error) {
+	if c == nil {
+		return nil, ErrNilCache
+	}
+	if c.onOperation != nil {
+		defer c.observe("GetFresh", c.now())
+	}
+	en := c.cache.get(k, c.hash(k))
+	now := c.now()
+	if en == nil {
+		c.recordMiss(nil, now)
+		return c.load(k)
+	}
+	if c.isCorrupted(en) {
+		c.recordCorruptedMiss()
+		c.discardCorrupted(en)
+		return c.load(k)
+	}
+	if c.isExpired(en, now) {
+		c.recordMiss(en, now)
+		return c.load(k)
+	}
+	if en.getWriteTime() < now.Add(-maxAge).UnixNano() {
+		c.stats.RecordMisses(1)
+		if st, ok := c.stats.(MissReasonStatsCounter); ok {
+			st.RecordMissReason(MissExpiredWrite)
 		}
+		return c.load(k)
 	}
+	c.stats.RecordHits(1)
+	c.setEntryAccessTime(en, now)
+	c.sendEvent(eventAccess, en)
+	return c.valueOf(en), nil
 }
 
-// sendEvent sends event only when the cache is not closing/closed.
-func (c *localCache) sendEvent(typ event, en *entry) {
-	if atomic.LoadInt32(&c.closing) == 0 {
-		c.events <- entryEvent{en, typ}
+// GetStrict returns value associated with k if it is not expired, otherwise
+// it loads the value synchronously and returns the error if loading fails.
+// Unlike Get, an expired value is never returned regardless of
+// WithExpiredServePolicy, and WithLoadFallback is not applied. This trades
+// latency for consistency: where Get returns the expired value immediately and
+// reloads it in background, every GetStrict of an expired entry waits for the
+// loader, and fails while the loader fails.
+func (c *localCache) GetStrict(k Key) (Value, error) {
+	if c == nil {
+		return nil, ErrNilCache
+	}
+	if c.onOperation != nil {
+		defer c.observe("GetStrict", c.now())
+	}
+	if c.loader == nil {
+		return nil, ErrNoLoader
+	}
+	en := c.cache.get(k, c.hash(k))
+	now := c.now()
+	if en == nil {
+		c.recordMiss(nil, now)
+		return c.loadShared(k, c.loader)
+	}
+	if c.isCorrupted(en) {
+		c.recordCorruptedMiss()
+		c.discardCorrupted(en)
+		return c.loadShared(k, c.loader)
+	}
+	if c.isExpired(en, now) {
+		c.recordMiss(en, now)
+		return c.loadShared(k, c.loader)
 	}
+	c.stats.RecordHits(1)
+	c.setEntryAccessTime(en, now)
+	c.sendEvent(eventAccess, en)
+	return c.valueOf(en), nil
 }
 
-// This function must only be called from processEntries goroutine.
-func (c *localCache) write(en *entry) {
-	ren := c.accessQueue.write(en)
-	c.writeQueue.write(en)
-	if c.onInsertion != nil {
-		c.onInsertion(en.key, en.getValue())
+// Refresh asynchronously reloads value for Key if it existed, otherwise
+// it will synchronously load and block until it value is loaded.
+// It returns true if a load or refresh was started. It does nothing and
+// returns false if the cache has no loader, the entry is already being
+// refreshed, or it was refreshed recently as set by WithRefreshDebounce.
+func (c *localCache) Refresh(k Key) bool {
+	if c == nil {
+		return false
 	}
-	if ren != nil {
+	if c.onOperation != nil {
+		defer c.observe("Refresh", c.now())
+	}
+	if c.loader == nil {
+		return false
+	}
+	en := c.cache.get(k, c.hash(k))
+	if en == nil {
+		c.load(k)
+		return true
+	}
+	return c.refreshAsync(en, nil)
+}
+
+// RefreshWithTimeout is like Refresh, but when k is not present it waits up to
+// d for the value to be loaded and returns ErrLoadTimeout if it is not.
+// Non-positive d means waiting until the value is loaded.
+// The load continues after the timeout and its value is stored when it completes.
+// It returns the error of the load, ErrNoLoader if the cache has no loader, or
+// nil if k is present and refreshed asynchronously.
+func (c *localCache) RefreshWithTimeout(k Key, d time.Duration) error {
+	if c == nil {
+		return ErrNilCache
+	}
+	if c.onOperation != nil {
+		defer c.observe("RefreshWithTimeout", c.now())
+	}
+	if c.loader == nil {
+		return ErrNoLoader
+	}
+	en := c.cache.get(k, c.hash(k))
+	if en != nil {
+		c.refreshAsync(en, nil)
+		return nil
+	}
+	if d <= 0 {
+		_, err := c.load(k)
+		return err
+	}
+	done := make(chan error, 1)
+	c.execute(func() {
+		_, err := c.load(k)
+		done <- err
+	})
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return ErrLoadTimeout
+	}
+}
+
+// LoadingKeys returns keys which are being loaded synchronously, or being
+// refreshed in background. It is intended for finding loads which are stuck,
+// e.g. because of a deadlocked loader, and the result may be outdated as soon
+// as it is returned.
+func (c *localCache) LoadingKeys() []Key {
+	if c == nil {
+		return nil
+	}
+	var keys []Key
+	c.loadMu.Lock()
+	for k, call := range c.loads {
+		if call.doneTime == 0 {
+			keys = append(keys, k)
+		}
+	}
+	c.loadMu.Unlock()
+	c.cache.walk(func(en *entry) {
+		if en.getLoading() {
+			keys = append(keys, en.key)
+		}
+	})
+	return keys
+}
+
+// PolicyName returns name of the cache policy, which is "slru" by default.
+func (c *localCache) PolicyName() string {
+	if c == nil {
+		return ""
+	}
+	if c.policyName == "" {
+		return defaultPolicy
+	}
+	return c.policyName
+}
+
+// Cap returns the current maximum number of entries of the cache, or 0 if it
+// is unlimited.
+func (c *localCache) Cap() int {
+	if c == nil {
+		return 0
+	}
+	cap := c.capacity()
+	if cap >= maximumCapacity {
+		return 0
+	}
+	return cap
+}
+
+// capacity returns the maximum number of entries used by the policy, which is
+// maximumCapacity or 0 if the cache is unlimited.
+func (c *localCache) capacity() int {
+	return int(atomic.LoadInt32(&c.cap))
+}
+
+// Utilization returns the number of entries divided by the maximum number of
+// entries of the cache, which is usually between 0 and 1 but can exceed 1
+// briefly while evictions are pending. It returns 0 if the cache is unlimited.
+func (c *localCache) Utilization() float64 {
+	cap := c.Cap()
+	if cap == 0 {
+		return 0
+	}
+	return float64(c.cache.len()) / float64(cap)
+}
+
+// Available returns the number of entries which can be added before the cache
+// starts evicting, i.e. the maximum number of entries minus the current one.
+// It is math.MaxInt32 if the cache is unlimited, and 0 if the cache is full
+// or briefly exceeds its maximum size while evictions are pending.
+func (c *localCache) Available() int {
+	if c == nil {
+		return 0
+	}
+	cap := c.Cap()
+	if cap == 0 {
+		return math.MaxInt32
+	}
+	n := cap - c.cache.len()
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// SetStrictCapacity switches between the approximate and exact enforcement of
+// the maximum size. See WithStrictCapacity.
+func (c *localCache) SetStrictCapacity(strict bool) {
+	if c == nil {
+		return
+	}
+	if strict {
+		atomic.StoreInt32(&c.strictCapacity, 1)
+	} else {
+		atomic.StoreInt32(&c.strictCapacity, 0)
+	}
+}
+
+// Stats copies cache stats to t.
+func (c *localCache) Stats(t *Stats) {
+	if c == nil {
+		*t = Stats{}
+		return
+	}
+	c.stats.Snapshot(t)
+}
+
+// StatsSnapshot returns a copy of cache statistics. Unlike Stats, it does not
+// allocate with the default stats counter.
+func (c *localCache) StatsSnapshot() Stats {
+	var t Stats
+	if c == nil {
+		return t
+	}
+	if st, ok := c.stats.(*statsCounter); ok {
+		// Call the concrete type so t does not escape to the heap.
+		st.Snapshot(&t)
+		return t
+	}
+	u := new(Stats)
+	c.stats.Snapshot(u)
+	return *u
+}
+
+// Hits returns the number of cache hits.
+func (c *localCache) Hits() uint64 {
+	if c == nil {
+		return 0
+	}
+	if r, ok := c.stats.(counterReader); ok {
+		return r.Hits()
+	}
+	var st Stats
+	c.stats.Snapshot(&st)
+	return st.HitCount
+}
+
+// Misses returns the number of cache misses.
+func (c *localCache) Misses() uint64 {
+	if c == nil {
+		return 0
+	}
+	if r, ok := c.stats.(counterReader); ok {
+		return r.Misses()
+	}
+	var st Stats
+	c.stats.Snapshot(&st)
+	return st.MissCount
+}
+
+// Evictions returns the number of evicted entries.
+func (c *localCache) Evictions() uint64 {
+	if c == nil {
+		return 0
+	}
+	if r, ok := c.stats.(counterReader); ok {
+		return r.Evictions()
+	}
+	var st Stats
+	c.stats.Snapshot(&st)
+	return st.EvictionCount
+}
+
+// ResetStats zeros cache stats. It has no effect if the stats counter given by
+// WithStatsCounter does not implement ResettableStatsCounter.
+func (c *localCache) ResetStats() {
+	if c == nil {
+		return
+	}
+	if st, ok := c.stats.(ResettableStatsCounter); ok {
+		st.Reset()
+	}
+}
+
+// EvictionOrder returns up to limit keys in the order they would be evicted
+// by the cache policy. Entries are not removed or accessed.
+func (c *localCache) EvictionOrder(limit int) []Key {
+	if c == nil {
+		return nil
+	}
+	var keys []Key
+	c.call(func() {
+		fn := func(en *entry) bool {
+			if len(keys) >= limit {
+				return false
+			}
+			if !en.getPinned() {
+				keys = append(keys, en.key)
+			}
+			return true
+		}
+		if p, ok := c.accessQueue.(evictionOrderer); ok {
+			p.evictionOrder(fn)
+		} else {
+			c.accessQueue.iterate(fn)
+		}
+	})
+	return keys
+}
+
+// RangeByAccessOrder calls fn for each entry which is not expired, from the
+// least recently accessed to the most, until fn returns false. Access times
+// are not changed. Entries are collected before fn is called, so fn may use
+// the cache but entries removed meanwhile are still visited.
+// The order is only meaningful for policies which maintain access order.
+func (c *localCache) RangeByAccessOrder(fn func(k Key, v Value) bool) {
+	if c == nil {
+		return
+	}
+	var entries []*entry
+	c.call(func() {
+		c.accessQueue.iterate(func(en *entry) bool {
+			entries = append(entries, en)
+			return true
+		})
+	})
+	now := c.now()
+	for _, en := range entries {
+		if c.isExpired(en, now) {
+			continue
+		}
+		if !fn(en.key, c.valueOf(en)) {
+			return
+		}
+	}
+}
+
+// ExpiredCountEstimate returns number of entries which are expired after access
+// but not yet removed. Only the least recently accessed entries, up to a limit,
+// are examined so the result is an estimate for large caches.
+func (c *localCache) ExpiredCountEstimate() int {
+	if c == nil {
+		return 0
+	}
+	if c.expireAfterAccess <= 0 {
+		return 0
+	}
+	n := 0
+	c.call(func() {
+		remain := expiredEstimateMax
+		expiry := c.now().Add(-c.expireAfterAccess).UnixNano()
+		c.accessQueue.iterate(func(en *entry) bool {
+			if en.getAccessTime() < expiry {
+				n++
+			}
+			remain--
+			return remain > 0
+		})
+	})
+	return n
+}
+
+func (c *localCache) processEntries() {
+	defer c.closeWG.Done()
+	defer close(c.closed)
+	for e := range c.events {
+		switch e.event {
+		case eventWrite:
+			// Clear the flag first so writes after this point are not skipped.
+			e.entry.setWriting(false)
+			c.write(e.entry)
+			c.postWriteCleanup()
+		case eventRefresh:
+			c.refreshed(e.entry)
+			c.postWriteCleanup()
+		case eventAccess:
+			c.access(e.entry)
+			c.postReadCleanup()
+		case eventDelete:
+			if c.isExpired(e.entry, c.now()) {
+				// Entry might be updated after the deletion was requested.
+				c.remove(e.entry)
+			}
+			c.postReadCleanup()
+		case eventCall:
+			e.fn()
+		case eventClose:
+			if c.exec != nil && !c.sharedExec {
+				// Stop all refresh tasks.
+				c.exec.Close()
+			}
+			c.releaseQueued()
+			if c.onClose != nil && !c.isAbandoned() {
+				c.onClose(c.liveEntries())
+			}
+			c.removeAll()
+			if c.onEmptyState != nil {
+				c.notifyEmptyState()
+			}
+			if c.insertions != nil {
+				close(c.insertions)
+			}
+			return
+		}
+		if c.onEmptyState != nil {
+			c.notifyEmptyState()
+		}
+	}
+}
+
+// notifyEmptyState calls the empty state listener if the cache has become
+// empty or non-empty since the last call.
+// This function must only be called from processEntries goroutine.
+func (c *localCache) notifyEmptyState() {
+	nonEmpty := c.cache.len() > 0
+	if nonEmpty != c.nonEmpty {
+		c.nonEmpty = nonEmpty
+		c.onEmptyState(!nonEmpty)
+	}
+}
+
+// sendEvent sends event only when the cache is not closing/closed.
+func (c *localCache) sendEvent(typ event, en *entry) {
+	if atomic.LoadInt32(&c.closing) != 0 {
+		return
+	}
+	if typ == eventAccess && c.nonBlockingHits {
+		select {
+		case c.events <- entryEvent{entry: en, event: typ}:
+		default:
+			if st, ok := c.stats.(DroppedHitStatsCounter); ok {
+				st.RecordDroppedHit()
+			}
+		}
+		return
+	}
+	c.enqueue(entryEvent{entry: en, event: typ})
+}
+
+// enqueue sends e to processEntries goroutine, reporting to the backpressure
+// observer if the events channel is full for too long.
+func (c *localCache) enqueue(e entryEvent) {
+	if c.onBackpressure == nil {
+		c.events <- e
+		return
+	}
+	select {
+	case c.events <- e:
+		return
+	default:
+	}
+	start := c.now()
+	c.events <- e
+	if d := c.now().Sub(start); d >= c.backpressureThreshold {
+		c.onBackpressure(e.event.String(), d)
+	}
+}
+
+// Health returns nil if the cache is operational, ErrClosed if it is closed, or
+// ErrUnresponsive if its processEntries goroutine does not run a probe within
+// a second, e.g. because a listener is blocked or the events channel is full.
+func (c *localCache) Health() error {
+	if c == nil {
+		return ErrNilCache
+	}
+	if atomic.LoadInt32(&c.closing) != 0 {
+		return ErrClosed
+	}
+	timer := time.NewTimer(healthTimeout)
+	defer timer.Stop()
+	done := make(chan struct{})
+	select {
+	case c.events <- entryEvent{event: eventCall, fn: func() { close(done) }}:
+	case <-c.closed:
+		return ErrClosed
+	case <-timer.C:
+		return ErrUnresponsive
+	}
+	select {
+	case <-done:
+		return nil
+	case <-c.closed:
+		return ErrClosed
+	case <-timer.C:
+		return ErrUnresponsive
+	}
+}
+
+// call runs fn in processEntries goroutine and waits until it is done.
+// It returns false if the cache is closed and fn was not run.
+func (c *localCache) call(fn func()) bool {
+	if atomic.LoadInt32(&c.closing) != 0 {
+		return false
+	}
+	done := make(chan struct{})
+	c.enqueue(entryEvent{event: eventCall, fn: func() {
+		fn()
+		close(done)
+	}})
+	select {
+	case <-done:
+		return true
+	case <-c.closed:
+		return false
+	}
+}
+
+// This function must only be called from processEntries goroutine.
+func (c *localCache) write(en *entry) {
+	if en.accessList == nil {
+		c.pressure.current(c.now()).insertions++
+	}
+	ren := c.accessQueue.write(en)
+	c.writeQueue.write(en)
+	// Notify the eviction first, so listeners mirroring the cache never
+	// see more entries than its capacity.
+	if ren != nil {
 		c.writeQueue.remove(ren)
+		c.unindexAll(ren)
 		// An entry has been evicted
-		c.stats.RecordEviction()
+		c.recordEviction(EvictionSize)
+		c.pressure.current(c.now()).evictions++
 		if c.onRemoval != nil {
-			c.onRemoval(ren.key, ren.getValue())
+			c.onRemoval(ren.key, c.valueOf(ren))
+		}
+	}
+	if len(c.indexes) > 0 {
+		c.reindex(en)
+	}
+	if c.onInsertion != nil {
+		c.notifyInsertion(en)
+	}
+}
+
+// notifyInsertion calls the insertion listener for the entry, or queues it
+// for the asynchronous listener. The notification is dropped if the queue is
+// full.
+// This function must only be called from processEntries goroutine.
+func (c *localCache) notifyInsertion(en *entry) {
+	if c.insertions == nil {
+		c.onInsertion(en.key, c.valueOf(en))
+		return
+	}
+	select {
+	case c.insertions <- Entry{Key: en.key, Value: c.valueOf(en)}:
+	default:
+		if st, ok := c.stats.(DroppedInsertionStatsCounter); ok {
+			st.RecordDroppedInsertion()
 		}
 	}
 }
 
+// notifyInsertions calls the insertion listener for queued notifications
+// until the cache is closed.
+func (c *localCache) notifyInsertions() {
+	for e := range c.insertions {
+		c.onInsertion(e.Key, e.Value)
+	}
+}
+
+// refreshed updates write order of a refreshed entry without changing its
+// position in the access queue. The entry is added again if it was removed
+// while refreshing.
+// This function must only be called from processEntries goroutine.
+func (c *localCache) refreshed(en *entry) {
+	if en.accessList == nil {
+		c.write(en)
+		return
+	}
+	c.writeQueue.write(en)
+	if len(c.indexes) > 0 {
+		c.reindex(en)
+	}
+	if c.onInsertion != nil {
+		c.notifyInsertion(en)
+	}
+}
+
+// liveEntries returns all entries which are not expired.
+// This function must only be called from processEntries goroutine.
+func (c *localCache) liveEntries() []Entry {
+	var entries []Entry
+	now := c.now()
+	c.accessQueue.iterate(func(en *entry) bool {
+		if !c.isExpired(en, now) {
+			entries = append(entries, Entry{Key: en.key, Value: c.valueOf(en)})
+		}
+		return true
+	})
+	return entries
+}
+
 // removeAll remove all entries in the cache.
 // This function must only be called from processEntries goroutine.
 func (c *localCache) removeAll() {
 	c.accessQueue.iterate(func(en *entry) bool {
+		if c.isAbandoned() {
+			// Closing timed out, stop calling listeners.
+			return false
+		}
 		c.remove(en)
 		return true
 	})
 }
 
+// isAbandoned returns true if closing the cache timed out.
+func (c *localCache) isAbandoned() bool {
+	return atomic.LoadInt32(&c.abandoned) != 0
+}
+
 // remove removes the given element from the cache and entries list.
 // It also calls onRemoval callback if it is set.
 func (c *localCache) remove(en *entry) {
+	ren := c.unlink(en)
+	if ren != nil && c.onRemoval != nil {
+		c.onRemoval(ren.key, c.valueOf(ren))
+	}
+}
+
+// unlink removes the given element from the cache and its indexes without
+// calling onRemoval. It returns nil if the entry was already removed.
+func (c *localCache) unlink(en *entry) *entry {
 	ren := c.accessQueue.remove(en)
 	c.writeQueue.remove(en)
-	if ren != nil && c.onRemoval != nil {
-		c.onRemoval(ren.key, ren.getValue())
+	if ren != nil {
+		c.unindexAll(ren)
 	}
+	return ren
+}
+
+// unindexAll removes the entry from tag, dependency and secondary indexes.
+// This function must only be called from processEntries goroutine.
+func (c *localCache) unindexAll(en *entry) {
+	c.untag(en)
+	c.undepend(en)
+	c.unindex(en)
 }
 
 // access moves the given element to the top of the entries list.
@@ -283,23 +1516,295 @@ func (c *localCache) access(en *entry) {
 	c.accessQueue.access(en)
 }
 
-// load uses current loader to synchronously retrieve value for k and adds new
-// entry to the cache only if loader returns a nil error.
+// loadCall is an in-flight or recently completed load of a key.
+type loadCall struct {
+	// done is closed when the load completes.
+	done chan struct{}
+	val  Value
+	err  error
+	// doneTime is when the load completed, only set when it is kept
+	// for reusing.
+	doneTime int64
+	// ttl is how long the result is kept after doneTime.
+	ttl time.Duration
+}
+
+// ErrChecksumMismatch is reported to the error handler when a cached value
+// does not match its checksum.
+var ErrChecksumMismatch = errors.New("cache: checksum mismatch")
+
+// ErrCloseTimeout is returned when the cache is not closed within the timeout.
+var ErrCloseTimeout = errors.New("cache: close timed out")
+
+// ErrClosed is returned by Health when the cache is closed.
+var ErrClosed = errors.New("cache: closed")
+
+// ErrUnresponsive is returned by Health when the cache does not process events
+// in time.
+var ErrUnresponsive = errors.New("cache: not responding")
+
+// ErrNoLoader is returned when a value needs to be loaded by a cache which has
+// no loader, e.g. by Get of a LoadingCache created without a LoaderFunc.
+var ErrNoLoader = errors.New("cache: no loader")
+
+// ErrNilCache is returned by Get and GetAndRefresh of a nil cache.
+var ErrNilCache = errors.New("cache: nil cache")
+
+// ErrServeStale can be returned, or wrapped, by a loader to keep serving the
+// current value of an entry when refreshing it fails permanently. The write time
+// of the entry is reset as if it was refreshed, so the next refresh is scheduled
+// after refreshAfterWrite again and the entry is not expired by expireAfterWrite,
+// while expireAfterAccess still applies. It has no effect when there is no
+// current value, in which case the error is returned by Get.
+var ErrServeStale = errors.New("cache: serve stale value")
+
+// ErrNotModified can be returned by a RefreshFunc when the value has not been
+// changed. The current value is kept and its write time is reset.
+var ErrNotModified = errors.New("cache: not modified")
+
+// ErrLoaderPanic is returned to callers waiting for a load which panicked.
+// It is also wrapped in the error returned by the panicked load itself when
+// WithLoaderPanicRecovery is set.
+var ErrLoaderPanic = errors.New("cache: loader panicked")
+
+// ErrLoadTimeout is returned by RefreshWithTimeout when the value is not
+// loaded within the timeout.
+var ErrLoadTimeout = errors.New("cache: load timed out")
+
+// ErrNilValue is reported to the error handler when a nil value is not stored
+// because of WithRejectNil.
+var ErrNilValue = errors.New("cache: nil value")
+
+// ErrInconsistent is reported to the error handler by WithConsistencyChecks
+// when an entry tracked by the cache policy is not the one stored in the cache,
+// or the other way around.
+var ErrInconsistent = errors.New("cache: inconsistent entry")
+
+// load retrieves value for k, sharing the result with concurrent loads of
+// the same key. Successful results are also shared with loads requested within
+// loadPromiseTTL after completion.
+// It returns ErrNoLoader if the cache has no loader.
 func (c *localCache) load(k Key) (Value, error) {
 	if c.loader == nil {
-		panic("cache loader function must be set")
+		return nil, ErrNoLoader
+	}
+	v, err := c.loadShared(k, c.loader)
+	if err != nil && c.loadFallback != nil {
+		return c.loadFallback(k), nil
+	}
+	return v, err
+}
+
+// callLoader calls loader for k, recovering from its panic if enabled.
+func (c *localCache) callLoader(loader LoaderFunc, k Key) (v Value, err error) {
+	defer c.recoverLoaderPanic(k, &err)
+	return loader(k)
+}
+
+// recoverLoaderPanic converts a panic of the loader to an error wrapping
+// ErrLoaderPanic and reports it to the error handler if recovery is enabled.
+// It must be called directly by defer.
+func (c *localCache) recoverLoaderPanic(k Key, err *error) {
+	if !c.recoverPanics {
+		return
+	}
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("%w: %v", ErrLoaderPanic, r)
+		if c.onError != nil {
+			c.onError(k, *err)
+		}
 	}
-	// TODO: Poll the value instead when the entry is loading.
-	start := currentTime()
-	v, err := c.loader(k)
-	now := currentTime()
+}
+
+// loadShared retrieves value for k using the given loader, sharing the result
+// with other loads of the same key. Waiting callers receive the result from
+// the load call when its done channel is closed instead of reading the cache,
+// so they get the value even if the entry has already been evicted.
+func (c *localCache) loadShared(k Key, loader LoaderFunc) (Value, error) {
+	return c.share(c.loads, true, k, loader)
+}
+
+// share retrieves value for k using the given loader, sharing the result with
+// other calls in the given map. The result is kept after the call completes
+// as set by WithLoadPromiseTTL and WithErrorCacheTTL only if keep is true.
+func (c *localCache) share(calls map[Key]*loadCall, keep bool, k Key, loader LoaderFunc) (Value, error) {
+	c.loadMu.Lock()
+	if call, ok := calls[k]; ok && !c.isLoadCallExpired(call) {
+		c.loadMu.Unlock()
+		if c.waitLoad(call) {
+			return call.val, call.err
+		}
+		// The load is taking too long, do not wait for it anymore.
+		c.loadMu.Lock()
+		c.startLoadLocked()
+		c.loadMu.Unlock()
+		defer c.endLoad()
+		return c.loadEntry(k, loader)
+	}
+	call := &loadCall{done: make(chan struct{}), err: ErrLoaderPanic}
+	calls[k] = call
+	c.startLoadLocked()
+	c.loadMu.Unlock()
+	defer c.endLoad()
+
+	defer c.finishLoad(calls, keep, k, call)
+	call.val, call.err = c.loadEntry(k, loader)
+	return call.val, call.err
+}
+
+// finishLoad releases callers waiting for the load and keeps the result in
+// calls for loadPromiseTTL, or errorCacheTTL if it failed, if it is set and
+// keep is true.
+func (c *localCache) finishLoad(calls map[Key]*loadCall, keep bool, k Key, call *loadCall) {
+	c.loadMu.Lock()
+	ttl := c.loadPromiseTTL
+	if !keep {
+		ttl = 0
+	} else if call.err != nil {
+		ttl = 0
+		if c.errorCacheTTL > 0 && isCacheableError(call.err) {
+			ttl = c.errorCacheTTL
+		}
+	}
+	if ttl > 0 {
+		call.doneTime = c.now().UnixNano()
+		call.ttl = ttl
+		time.AfterFunc(ttl, func() {
+			c.loadMu.Lock()
+			if calls[k] == call {
+				delete(calls, k)
+			}
+			c.loadMu.Unlock()
+		})
+	} else if calls[k] == call {
+		// The call may have been forgotten and replaced by another one.
+		delete(calls, k)
+	}
+	c.loadMu.Unlock()
+	close(call.done)
+}
+
+// forgetLoad drops the load of k, so that its result kept by WithLoadPromiseTTL
+// or WithErrorCacheTTL is not returned anymore, and loads started afterward do
+// not wait for an in-flight load.
+func (c *localCache) forgetLoad(k Key) {
+	c.loadMu.Lock()
+	delete(c.loads, k)
+	c.loadMu.Unlock()
+}
+
+// forgetLoads drops loads of all keys like forgetLoad.
+func (c *localCache) forgetLoads() {
+	c.loadMu.Lock()
+	for k := range c.loads {
+		delete(c.loads, k)
+	}
+	c.loadMu.Unlock()
+}
+
+// startLoadLocked counts a running load or refresh. loadMu must be held.
+func (c *localCache) startLoadLocked() {
+	if c.running == 0 {
+		c.idle = make(chan struct{})
+	}
+	c.running++
+}
+
+// endLoad counts a finished load or refresh.
+func (c *localCache) endLoad() {
+	c.loadMu.Lock()
+	c.endLoadLocked()
+	c.loadMu.Unlock()
+}
+
+// endLoadLocked counts a finished load or refresh. loadMu must be held.
+func (c *localCache) endLoadLocked() {
+	c.running--
+	if c.running == 0 {
+		close(c.idle)
+	}
+}
+
+// WaitForLoads blocks until there are no loads or refreshes running, or the
+// cache is closed. Loads started before the running ones finish are also
+// waited for.
+// It is useful to let background refreshes settle, e.g. in tests or before
+// closing the cache.
+func (c *localCache) WaitForLoads() {
+	if c == nil {
+		return
+	}
+	c.loadMu.Lock()
+	if c.running == 0 {
+		c.loadMu.Unlock()
+		return
+	}
+	idle := c.idle
+	c.loadMu.Unlock()
+	select {
+	case <-idle:
+	case <-c.closed:
+	}
+}
+
+// waitLoad waits for the load to complete and returns false if it does not
+// complete within loadWaitTimeout.
+func (c *localCache) waitLoad(call *loadCall) bool {
+	if c.loadWaitTimeout <= 0 {
+		<-call.done
+		return true
+	}
+	timer := time.NewTimer(c.loadWaitTimeout)
+	defer timer.Stop()
+	select {
+	case <-call.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// isLoadCallExpired returns true if the completed load can no longer be reused.
+// loadMu must be held.
+func (c *localCache) isLoadCallExpired(call *loadCall) bool {
+	return call.doneTime > 0 && call.doneTime <= c.now().Add(-call.ttl).UnixNano()
+}
+
+// temporary is implemented by errors which can be resolved by retrying,
+// like net.Error.
+type temporary interface {
+	Temporary() bool
+}
+
+// isCacheableError returns false if the load error is temporary, i.e. it or
+// any error it wraps has a Temporary method returning true, or a panic.
+func isCacheableError(err error) bool {
+	if errors.Is(err, ErrLoaderPanic) {
+		return false
+	}
+	var t temporary
+	return !errors.As(err, &t) || !t.Temporary()
+}
+
+// loadEntry uses the given loader to synchronously retrieve value for k and adds new
+// entry to the cache only if loader returns a nil error.
+func (c *localCache) loadEntry(k Key, loader LoaderFunc) (Value, error) {
+	start := c.now()
+	v, err := c.callLoader(loader, k)
+	now := c.now()
 	loadTime := now.Sub(start)
 	if err != nil {
 		c.stats.RecordLoadError(loadTime)
 		return nil, err
 	}
-	c.stats.RecordLoadSuccess(loadTime)
-	en := newEntry(k, v, sum(k))
+	c.recordLoadSuccess(k, v, loadTime)
+	v = c.transform(v)
+	if c.rejectsNil(k, v) {
+		// Return the value to the caller without storing it.
+		return v, nil
+	}
+	en := newEntry(k, c.compress(v), c.hash(k))
+	c.setEntryChecksum(en)
 	c.setEntryWriteTime(en, now)
 	c.setEntryAccessTime(en, now)
 	c.sendEvent(eventWrite, en)
@@ -307,40 +1812,252 @@ func (c *localCache) load(k Key) (Value, error) {
 }
 
 // refreshAsync reloads value in a go routine or using custom executor if defined.
-func (c *localCache) refreshAsync(en *entry) bool {
+// done is called with the result if it is not nil and the reload is started.
+// It returns false without reloading if the cache has no loader.
+func (c *localCache) refreshAsync(en *entry, done func(Value, error)) bool {
 	if c.loader == nil {
-		panic("cache loader function must be set")
+		return false
+	}
+	if c.refreshDebounce > 0 {
+		now := c.now()
+		if tm := en.getRefreshTime(); tm > 0 && tm > now.Add(-c.refreshDebounce).UnixNano() {
+			// Refreshed recently.
+			return false
+		}
 	}
 	if en.setLoading(true) {
-		// Only do refresh if it isn't running.
-		if c.exec == nil {
-			go c.refresh(en)
-		} else {
-			c.exec.Execute(func() { c.refresh(en) })
+		if c.refreshDebounce > 0 {
+			en.setRefreshTime(c.now().UnixNano())
 		}
+		c.loadMu.Lock()
+		c.startLoadLocked()
+		c.queued[en] = struct{}{}
+		c.loadMu.Unlock()
+		// Only do refresh if it isn't running.
+		c.execute(func() {
+			if c.startQueued(en) {
+				c.refresh(en, done)
+			}
+		})
 		return true
 	}
 	return false
 }
 
+// startQueued marks the queued refresh of en started. It returns false if the
+// refresh has been released by releaseQueued and must not run.
+func (c *localCache) startQueued(en *entry) bool {
+	c.loadMu.Lock()
+	_, ok := c.queued[en]
+	delete(c.queued, en)
+	c.loadMu.Unlock()
+	return ok
+}
+
+// releaseQueued releases load state of refreshes which have not been started,
+// e.g. discarded by the executor when the cache is closed, so that they are
+// neither waited for by WaitForLoads nor reported by LoadingKeys.
+func (c *localCache) releaseQueued() {
+	c.loadMu.Lock()
+	for en := range c.queued {
+		delete(c.queued, en)
+		en.setLoading(false)
+		c.endLoadLocked()
+	}
+	c.loadMu.Unlock()
+}
+
+// execute runs fn asynchronously by the executor if there is one, otherwise
+// in a new go routine.
+func (c *localCache) execute(fn func()) {
+	if c.exec == nil {
+		go fn()
+	} else {
+		c.exec.Execute(fn)
+	}
+}
+
 // refresh reloads value for the given key. If loader returns an error,
 // that error will be omitted. Otherwise, the entry value will be updated.
 // This function would only be called by refreshAsync.
-func (c *localCache) refresh(en *entry) {
+func (c *localCache) refresh(en *entry, done func(Value, error)) {
+	defer c.endLoad()
 	defer en.setLoading(false)
 
-	start := currentTime()
-	v, err := c.loader(en.key)
-	now := currentTime()
+	start := c.now()
+	var v Value
+	var err error
+	if c.refreshFunc != nil {
+		v, err = c.callLoader(func(k Key) (Value, error) {
+			return c.refreshFunc(k, c.valueOf(en), unixTime(en.getWriteTime()))
+		}, en.key)
+	} else {
+		v, err = c.callLoader(c.loader, en.key)
+	}
+	now := c.now()
 	loadTime := now.Sub(start)
-	if err == nil {
-		c.stats.RecordLoadSuccess(loadTime)
-		en.setValue(v)
+	if errors.Is(err, ErrNotModified) {
+		// Keep the current value as it is still fresh.
+		if !c.recordRefreshSuccess(loadTime) {
+			c.stats.RecordLoadSuccess(loadTime)
+		}
 		en.setWriteTime(now.UnixNano())
-		c.sendEvent(eventWrite, en)
+		en.setStale(false)
+		c.setEntryRefreshJitter(en)
+		c.sendEvent(eventRefresh, en)
+	} else if err == nil {
+		if !c.recordRefreshSuccess(loadTime) {
+			c.recordLoadSuccess(en.key, v, loadTime)
+		}
+		v = c.transform(v)
+		if c.rejectsNil(en.key, v) {
+			// Keep the current value, the next access refreshes it again.
+			if done != nil {
+				done(v, err)
+			}
+			return
+		}
+		en.setValue(c.compress(v))
+		c.setEntryChecksum(en)
+		en.setWriteTime(now.UnixNano())
+		en.setStale(false)
+		c.setEntryRefreshJitter(en)
+		if c.refreshPreservesRecency {
+			c.sendEvent(eventRefresh, en)
+		} else {
+			c.sendEvent(eventWrite, en)
+		}
 	} else {
 		// TODO: Log error
-		c.stats.RecordLoadError(loadTime)
+		if st, ok := c.stats.(RefreshStatsCounter); ok {
+			st.RecordRefreshError(loadTime)
+		} else {
+			c.stats.RecordLoadError(loadTime)
+		}
+		if errors.Is(err, ErrServeStale) {
+			// Keep the current value and postpone the next refresh.
+			en.setWriteTime(now.UnixNano())
+			en.setStale(false)
+			c.setEntryRefreshJitter(en)
+			c.sendEvent(eventRefresh, en)
+		} else if c.expiredServe == ServeExpiredUntilRefreshFails && c.isExpired(en, now) {
+			en.setInvalidated(true)
+			c.sendEvent(eventDelete, en)
+		}
+	}
+	if done != nil {
+		done(v, err)
+	}
+}
+
+// rejectsNil returns true if v must not be stored because it is nil and
+// WithRejectNil is set, reporting ErrNilValue to the error handler.
+func (c *localCache) rejectsNil(k Key, v Value) bool {
+	if !c.rejectNil || v != nil {
+		return false
+	}
+	if c.onError != nil {
+		c.onError(k, ErrNilValue)
+	}
+	return true
+}
+
+// transform returns v transformed by the value transform if there is one.
+func (c *localCache) transform(v Value) Value {
+	if c.valueTransform == nil {
+		return v
+	}
+	return c.valueTransform(v)
+}
+
+// now returns the current time, read from the monotonic clock if
+// WithMonotonicClock is set.
+func (c *localCache) now() time.Time {
+	if c.monotonicClock {
+		return monotonicTime()
+	}
+	return currentTime()
+}
+
+// observe reports the duration of the operation since start.
+func (c *localCache) observe(op string, start time.Time) {
+	c.onOperation(op, c.now().Sub(start))
+}
+
+// recordMiss records a cache miss of the given entry, which is nil if the key
+// is absent, including the reason if the stats counter supports it.
+func (c *localCache) recordMiss(en *entry, now time.Time) {
+	c.stats.RecordMisses(1)
+	st, ok := c.stats.(MissReasonStatsCounter)
+	if !ok {
+		return
+	}
+	var reason MissReason
+	switch {
+	case en == nil:
+		reason = MissAbsent
+	case en.getInvalidated():
+		reason = MissInvalidated
+	case en.getStale():
+		reason = MissStale
+	case c.expireAfterAccess > 0 && en.getAccessTime() < now.Add(-c.expireAfterAccess).UnixNano():
+		reason = MissExpiredAccess
+	default:
+		reason = MissExpiredWrite
+	}
+	st.RecordMissReason(reason)
+}
+
+// recordCorruptedMiss records a miss of an entry which does not match its
+// checksum.
+func (c *localCache) recordCorruptedMiss() {
+	c.stats.RecordMisses(1)
+	if st, ok := c.stats.(MissReasonStatsCounter); ok {
+		st.RecordMissReason(MissCorrupted)
+	}
+}
+
+// recordRefreshSuccess records a successful refresh if the stats counter
+// records refreshes separately. Otherwise it returns false and the refresh
+// should be recorded as a load.
+func (c *localCache) recordRefreshSuccess(loadTime time.Duration) bool {
+	st, ok := c.stats.(RefreshStatsCounter)
+	if ok {
+		st.RecordRefreshSuccess(loadTime)
+	}
+	return ok
+}
+
+// recordLoadSuccess records a successful load including weight of the value
+// if the value can be weighed and the stats counter supports it.
+func (c *localCache) recordLoadSuccess(k Key, v Value, loadTime time.Duration) {
+	if st, ok := c.stats.(WeightStatsCounter); ok {
+		if w, ok := c.weigh(k, v); ok {
+			st.RecordLoadSuccessWeight(loadTime, w)
+			return
+		}
+	}
+	c.stats.RecordLoadSuccess(loadTime)
+}
+
+// weigh returns weight of the entry given by the weigher, or by the value
+// itself if it implements Sizer. It returns false if neither is available.
+func (c *localCache) weigh(k Key, v Value) (uint64, bool) {
+	if c.weigher != nil {
+		return c.weigher(k, v), true
+	}
+	if s, ok := v.(Sizer); ok {
+		return s.Size(), true
+	}
+	return 0, false
+}
+
+// recordEviction records an eviction with its cause if the stats counter supports it.
+func (c *localCache) recordEviction(cause EvictionCause) {
+	if st, ok := c.stats.(CauseStatsCounter); ok {
+		st.RecordEvictionCause(cause)
+	} else {
+		c.stats.RecordEviction()
 	}
 }
 
@@ -357,14 +2074,40 @@ func (c *localCache) postReadCleanup() {
 // This function must only be called from processEntries goroutine.
 func (c *localCache) postWriteCleanup() {
 	atomic.StoreInt32(&c.readCount, 0)
+	if c.consistencyChecks && c.onError != nil {
+		c.uncheckedWrites++
+		if c.uncheckedWrites >= drainMax {
+			c.uncheckedWrites = 0
+			c.checkConsistency()
+		}
+	}
 	c.expireEntries()
 }
 
 // expireEntries removes expired entries.
+//
+// The number of entries removed is limited by drainMax. When all of them were
+// expired, the limit is doubled for the next clean up, up to drainMaxBurst, so
+// that entries accumulated during idle time are reclaimed faster.
 func (c *localCache) expireEntries() {
-	remain := drainMax
-	now := currentTime()
-	if c.expireAfterAccess > 0 {
+	if c.drainLimit < drainMax {
+		c.drainLimit = drainMax
+	}
+	limit := c.drainLimit
+	remain := limit
+	now := c.now()
+	// Expired entries may be kept to be served while refreshing, or until
+	// they are read.
+	keepExpired := c.lazyExpiry || c.expiredServe == ServeExpiredAlways && c.loader != nil
+	var expired []Entry
+	expire := func(en *entry) {
+		c.remove(en)
+		c.recordEviction(EvictionExpired)
+		if c.onExpiryBatch != nil {
+			expired = append(expired, Entry{Key: en.key, Value: c.valueOf(en)})
+		}
+	}
+	if !keepExpired && c.expireAfterAccess > 0 {
 		expiry := now.Add(-c.expireAfterAccess).UnixNano()
 		c.accessQueue.iterate(func(en *entry) bool {
 			if remain == 0 || en.getAccessTime() >= expiry {
@@ -373,44 +2116,56 @@ func (c *localCache) expireEntries() {
 				return false
 			}
 			// accessTime + expiry passed
-			c.remove(en)
-			c.stats.RecordEviction()
+			expire(en)
 			remain--
 			return remain > 0
 		})
 	}
-	if remain > 0 && c.expireAfterWrite > 0 {
+	if remain > 0 && !keepExpired && c.expireAfterWrite > 0 {
 		expiry := now.Add(-c.expireAfterWrite).UnixNano()
 		c.writeQueue.iterate(func(en *entry) bool {
 			if remain == 0 || en.getWriteTime() >= expiry {
 				return false
 			}
 			// writeTime + expiry passed
-			c.remove(en)
-			c.stats.RecordEviction()
+			expire(en)
 			remain--
 			return remain > 0
 		})
 	}
+	if len(expired) > 0 {
+		c.onExpiryBatch(expired)
+	}
+	if remain == 0 {
+		c.drainLimit = limit * 2
+		if c.drainLimit > drainMaxBurst {
+			c.drainLimit = drainMaxBurst
+		}
+	} else {
+		c.drainLimit = drainMax
+	}
 	if remain > 0 && c.loader != nil && c.refreshAfterWrite > 0 {
 		expiry := now.Add(-c.refreshAfterWrite).UnixNano()
 		c.writeQueue.iterate(func(en *entry) bool {
 			if remain == 0 || en.getWriteTime() >= expiry {
 				return false
 			}
-			// FIXME: This can cause deadlock if the custom executor runs refresh in current go routine.
-			// The refresh function, when finish, will send to event channels.
-			if c.refreshAsync(en) {
+			// Entries which refresh is delayed by jitter, rejected or running
+			// are counted too, so that a clean up does not walk all of them.
+			if c.needRefresh(en, now) {
+				// FIXME: This can cause deadlock if the custom executor runs refresh in current go routine.
+				// The refresh function, when finish, will send to event channels.
 				// TODO: Maybe move this entry up?
-				remain--
+				c.refreshAsync(en, nil)
 			}
+			remain--
 			return remain > 0
 		})
 	}
 }
 
 func (c *localCache) isExpired(en *entry, now time.Time) bool {
-	if en.getInvalidated() {
+	if en.getInvalidated() || en.getStale() {
 		return true
 	}
 	if c.expireAfterAccess > 0 && en.getAccessTime() < now.Add(-c.expireAfterAccess).UnixNano() {
@@ -430,14 +2185,71 @@ func (c *localCache) needRefresh(en *entry, now time.Time) bool {
 	}
 	if c.refreshAfterWrite > 0 {
 		tm := en.getWriteTime()
-		if tm > 0 && tm < now.Add(-c.refreshAfterWrite).UnixNano() {
-			// writeTime + refresh passed
-			return true
+		if tm > 0 && tm+en.getRefreshJitter() < now.Add(-c.refreshAfterWrite).UnixNano() {
+			// writeTime + refresh + jitter passed
+			return c.refreshPredicate == nil || c.refreshPredicate(en.key, c.valueOf(en))
 		}
 	}
 	return false
 }
 
+// isCorrupted returns true if integrity check is enabled and the entry value
+// does not match its checksum.
+func (c *localCache) isCorrupted(en *entry) bool {
+	if c.checksum == nil {
+		return false
+	}
+	// Verify again in case the value was being updated concurrently.
+	for i := 0; i < 2; i++ {
+		if c.checksum(en.getValue()) == en.getChecksum() {
+			return false
+		}
+	}
+	return true
+}
+
+// checkConsistency reports keys which entries tracked by the policy differ from
+// the ones stored in the cache. Entries which are stored but not yet added to
+// the policy are not reported, as their write events may still be pending.
+// This function must only be called from processEntries goroutine.
+func (c *localCache) checkConsistency() {
+	tracked := make(map[*entry]struct{}, c.cache.len())
+	fn := func(en *entry) bool {
+		tracked[en] = struct{}{}
+		if c.cache.get(en.key, en.hash) != en {
+			// The entry has been removed or replaced by a duplicate.
+			c.onError(en.key, ErrInconsistent)
+		}
+		return true
+	}
+	if p, ok := c.accessQueue.(evictionOrderer); ok {
+		p.evictionOrder(fn)
+	} else {
+		c.accessQueue.iterate(fn)
+	}
+	c.cache.walk(func(en *entry) {
+		if _, ok := tracked[en]; !ok && en.accessList != nil {
+			c.onError(en.key, ErrInconsistent)
+		}
+	})
+}
+
+// discardCorrupted reports and removes a corrupted entry.
+func (c *localCache) discardCorrupted(en *entry) {
+	if c.onError != nil {
+		c.onError(en.key, ErrChecksumMismatch)
+	}
+	en.setInvalidated(true)
+	c.sendEvent(eventDelete, en)
+}
+
+// setEntryChecksum sets checksum of the entry value if needed.
+func (c *localCache) setEntryChecksum(en *entry) {
+	if c.checksum != nil {
+		en.setChecksum(c.checksum(en.getValue()))
+	}
+}
+
 // setEntryAccessTime sets access time if needed.
 func (c *localCache) setEntryAccessTime(en *entry, now time.Time) {
 	if c.expireAfterAccess > 0 {
@@ -445,10 +2257,18 @@ func (c *localCache) setEntryAccessTime(en *entry, now time.Time) {
 	}
 }
 
-// setEntryWriteTime sets write time if needed.
+// setEntryWriteTime sets write time of the entry, which is always kept for GetFresh.
 func (c *localCache) setEntryWriteTime(en *entry, now time.Time) {
-	if c.expireAfterWrite > 0 || c.refreshAfterWrite > 0 {
-		en.setWriteTime(now.UnixNano())
+	en.setWriteTime(now.UnixNano())
+	en.setStale(false)
+	c.setEntryRefreshJitter(en)
+}
+
+// setEntryRefreshJitter sets a random refresh delay if refresh jitter is enabled.
+func (c *localCache) setEntryRefreshJitter(en *entry) {
+	if c.refreshJitter > 0 && c.refreshAfterWrite > 0 {
+		max := int64(float64(c.refreshAfterWrite) * c.refreshJitter)
+		en.setRefreshJitter(rand.Int63n(max + 1))
 	}
 }
 
@@ -462,6 +2282,16 @@ func New(options ...Option) Cache {
 	return c
 }
 
+// Disabled returns a nil cache, which can be used in place of a cache when
+// caching is turned off. A nil cache always misses: GetIfPresent returns
+// (nil, false), Put and other updates do nothing, GetOrSet returns the value
+// created by the factory without storing it, and Get returns ErrNilCache.
+//
+// Note that calling methods of a nil Cache interface value still panics.
+func Disabled() LoadingCache {
+	return (*localCache)(nil)
+}
+
 // NewLoadingCache returns a new LoadingCache with given loader function
 // and cache options.
 func NewLoadingCache(loader LoaderFunc, options ...Option) LoadingCache {
@@ -479,6 +2309,13 @@ type Option func(c *localCache)
 
 // WithMaximumSize returns an Option which sets maximum size for the cache.
 // Any non-positive numbers is considered as unlimited.
+//
+// The size is enforced when writes are processed asynchronously, so concurrent
+// Puts of new keys may briefly exceed it. With size 1, the cache keeps the most
+// recently written entry under lru, slru, tinylfu, lfu, sampled and mru
+// policies, as slru and tinylfu have no room for a protected segment or an
+// admission window, and sampled and mru evict the only existing entry, while
+// priority policy keeps the entry with the highest priority.
 func WithMaximumSize(size int) Option {
 	if size < 0 {
 		size = 0
@@ -487,22 +2324,271 @@ func WithMaximumSize(size int) Option {
 		size = maximumCapacity
 	}
 	return func(c *localCache) {
-		c.cap = size
+		c.cap = int32(size)
+	}
+}
+
+// WithAdaptiveSize returns an Option which adjusts maximum size of the cache
+// within the given bounds. Every minute, the size is increased by 10% if entries
+// were evicted while the hit rate was below 90%, or decreased by 10% if there
+// were no evictions and the cache is less than half full.
+// This option overrides WithMaximumSize, which is then used as the initial size.
+func WithAdaptiveSize(min, max int) Option {
+	if min < 1 {
+		min = 1
+	}
+	if max > maximumCapacity {
+		max = maximumCapacity
+	}
+	if max < min {
+		max = min
+	}
+	return func(c *localCache) {
+		c.adaptiveMin = min
+		c.adaptiveMax = max
+	}
+}
+
+// WithMultiValue returns an option which limits the number of values kept
+// for a key by Append. By default, the number is unlimited.
+func WithMultiValue(maxPerKey int) Option {
+	return func(c *localCache) {
+		c.maxValuesPerKey = maxPerKey
+	}
+}
+
+// WithEmptyStateListener returns an Option to set cache to call onEmptyState
+// with true when the last entry is removed from the cache, and with false when
+// the first entry is added to an empty cache. It is only called when the state
+// changes after processing each write or removal, so evicting the only entry
+// of a cache when adding another one is not notified.
+// Like other listeners, it is called from the goroutine maintaining the cache
+// and must not block, nor call the methods listed in Cache which wait for it.
+func WithEmptyStateListener(onEmptyState func(empty bool)) Option {
+	return func(c *localCache) {
+		c.onEmptyState = onEmptyState
+	}
+}
+
+// WithSecondaryIndex returns an Option which indexes cached values by the
+// attribute returned by extract, so that they can be found by GetBySecondary
+// using the index name. Attributes should be unique among values, otherwise
+// only the last written value is found.
+//
+// The index costs a map entry per cached value and extract is called on every
+// write, from the goroutine maintaining the cache. It is updated when writes,
+// evictions and removals are processed, so it is eventually consistent with
+// the cache.
+func WithSecondaryIndex(name string, extract func(Value) string) Option {
+	return func(c *localCache) {
+		c.indexes = append(c.indexes, &secondaryIndex{
+			name:    name,
+			extract: extract,
+			entries: make(map[string]*entry),
+		})
+	}
+}
+
+// WithHashSeed returns an Option which mixes the given seed into hash values
+// of keys. By default, hash values are deterministic, so when keys are
+// controlled by an attacker, e.g. derived from request parameters, they can be
+// chosen to collide, concentrating entries in one internal segment and
+// skewing frequency estimates of TinyLFU. A secret seed makes such collisions
+// unpredictable. Keys implementing Hash or of unsupported types, which hash to
+// the same value without the seed, still collide.
+func WithHashSeed(seed uint64) Option {
+	return func(c *localCache) {
+		c.hashSeed = seed
+	}
+}
+
+// WithRandomHashSeed returns an Option which mixes a random seed into hash
+// values of keys. See WithHashSeed.
+func WithRandomHashSeed() Option {
+	return func(c *localCache) {
+		c.hashSeed = randomSeed()
 	}
 }
 
 // WithRemovalListener returns an Option to set cache to call onRemoval for each
 // entry evicted from the cache.
+// When adding an entry evicts another one, onRemoval is called for the evicted
+// entry before the insertion listener is called for the added one.
+// See Cache for the methods which must not be called from listeners.
 func WithRemovalListener(onRemoval Func) Option {
 	return func(c *localCache) {
 		c.onRemoval = onRemoval
 	}
 }
 
+// WithOnClose returns an Option to set cache to call onClose with all live
+// entries when the cache is closed, before they are removed.
+// It can be used to persist remaining entries.
+func WithOnClose(onClose func([]Entry)) Option {
+	return func(c *localCache) {
+		c.onClose = onClose
+	}
+}
+
+// WithReplacementListener returns an Option which calls onReplacement with the
+// old and new value when Put, or other methods storing a value like it, replaces
+// the value of a live entry. Values of expired entries are not reported.
+// It is called synchronously by the goroutine calling Put, so concurrent Puts of
+// the same key may report values in a different order than they are stored.
+func WithReplacementListener(onReplacement func(k Key, old, new Value)) Option {
+	return func(c *localCache) {
+		c.onReplacement = onReplacement
+	}
+}
+
+// WithLazyExpiryOnly returns an Option which removes expired entries only when
+// they are read, instead of also sweeping them after cache operations. It saves
+// the cost of the sweeps, but expired entries which are not read again are kept
+// in memory until they are evicted by the maximum size, so it should only be
+// used with a bounded cache. Entries are still refreshed by WithRefreshAfterWrite.
+func WithLazyExpiryOnly() Option {
+	return func(c *localCache) {
+		c.lazyExpiry = true
+	}
+}
+
+// WithBatchExpiryListener returns an Option which calls onExpiry once per
+// clean up with all entries expired in it, in addition to the removal listener
+// called for each entry. It is called from the goroutine processing cache events.
+// Expired entries removed otherwise, e.g. when they are read, are not included.
+// See Cache for the methods which must not be called from listeners.
+func WithBatchExpiryListener(onExpiry func([]Entry)) Option {
+	return func(c *localCache) {
+		c.onExpiryBatch = onExpiry
+	}
+}
+
+// WithMonotonicClock returns an Option which measures access and write times of
+// entries with the monotonic clock instead of the wall clock, so that entries
+// neither expire early nor late when the wall clock is stepped, e.g. by NTP.
+// The times are still reported as wall clock times relative to the start of
+// the process, so they drift from the wall clock by the adjustments made since
+// then, which also applies to times written by DumpTo and given to
+// PutWithTimes.
+func WithMonotonicClock() Option {
+	return func(c *localCache) {
+		c.monotonicClock = true
+	}
+}
+
+// WithConsistencyChecks returns an Option which verifies once every 16 writes
+// that entries tracked by the cache policy are the ones stored in the cache, and
+// reports each mismatched key with ErrInconsistent to the error handler set by
+// WithErrorHandler. It is intended for debugging as each check walks all
+// entries, so it is disabled by default.
+func WithConsistencyChecks() Option {
+	return func(c *localCache) {
+		c.consistencyChecks = true
+	}
+}
+
+// WithErrorHandler returns an Option to set cache to call onError when an error
+// associated with an entry is detected.
+func WithErrorHandler(onError func(Key, error)) Option {
+	return func(c *localCache) {
+		c.onError = onError
+	}
+}
+
+// WithLoaderPanicRecovery returns an Option which recovers from panics of the
+// loader, including the refresh function. A panicked load returns an error
+// wrapping ErrLoaderPanic, which is also reported to the error handler,
+// instead of crashing the caller. A panicked refresh keeps the current value.
+func WithLoaderPanicRecovery() Option {
+	return func(c *localCache) {
+		c.recoverPanics = true
+	}
+}
+
+// WithLoadFallback returns an Option which makes Get return the value given by
+// fallback instead of an error when loading a missing or expired value fails.
+// The fallback value is not stored in the cache, so the next Get loads again.
+// Panics of the loader are recovered as with WithLoaderPanicRecovery.
+func WithLoadFallback(fallback func(k Key) Value) Option {
+	return func(c *localCache) {
+		c.loadFallback = fallback
+		c.recoverPanics = true
+	}
+}
+
+// WithRejectNil returns an Option which prevents nil values from being stored
+// by Put, Update and loads. A nil value returned by the loader is still returned
+// to the caller, and a nil value returned by a refresh keeps the current value.
+// Each rejected value is reported to the error handler as ErrNilValue.
+func WithRejectNil() Option {
+	return func(c *localCache) {
+		c.rejectNil = true
+	}
+}
+
+// WithInvalidationBroadcaster returns an option which calls broadcast with
+// the key every time Invalidate is called, whether or not the key is present,
+// so that the invalidation can be propagated to other cache instances.
+// Invalidations received from other instances should be applied with
+// InvalidateLocal, which does not call broadcast again.
+func WithInvalidationBroadcaster(broadcast func(Key)) Option {
+	return func(c *localCache) {
+		c.onInvalidate = broadcast
+	}
+}
+
+// WithOperationObserver returns an option which calls observe at the end of
+// each data operation with its method name, e.g. "Get" or "Put", and the time
+// it took including event sending and loading. Operations which call others,
+// like GetIfPresent with WithReadThrough, are observed for each of them.
+func WithOperationObserver(observe func(op string, d time.Duration)) Option {
+	return func(c *localCache) {
+		c.onOperation = observe
+	}
+}
+
+// WithChannelBuffer returns an option which sets the buffer size of the channel
+// used to send events to the goroutine maintaining the cache. The default is 64.
+// A larger buffer absorbs bursts of writes without blocking callers, at the cost
+// of memory and of a larger window in which the cache can exceed its maximum
+// size before pending writes are processed. Non-positive size uses the default.
+func WithChannelBuffer(size int) Option {
+	if size < 1 {
+		size = chanBufSize
+	}
+	return func(c *localCache) {
+		c.bufSize = size
+	}
+}
+
+// WithBackpressureObserver returns an option which calls observe when an
+// operation is blocked for at least the given threshold because the events
+// channel is full, with name of the event, e.g. "write" or "access", and the
+// time it was blocked. Frequent calls indicate that the single goroutine
+// processing events cannot keep up and the cache should be sharded.
+func WithBackpressureObserver(threshold time.Duration, observe func(event string, blockedFor time.Duration)) Option {
+	return func(c *localCache) {
+		c.backpressureThreshold = threshold
+		c.onBackpressure = observe
+	}
+}
+
+// WithIntegrityCheck returns an Option which computes checksum of values when they
+// are stored and verifies it when they are read. A value which does not match
+// its checksum, e.g. it was modified in place, is treated as a miss, removed
+// from the cache and reported to the error handler with ErrChecksumMismatch.
+// This option adds the cost of computing the checksum to every read and write.
+func WithIntegrityCheck(checksum func(Value) uint64) Option {
+	return func(c *localCache) {
+		c.checksum = checksum
+	}
+}
+
 // WithExpireAfterAccess returns an option to expire a cache entry after the
 // given duration without being accessed.
 func WithExpireAfterAccess(d time.Duration) Option {
 	return func(c *localCache) {
+		c.checkDuration("WithExpireAfterAccess", d)
 		c.expireAfterAccess = d
 	}
 }
@@ -511,18 +2597,189 @@ func WithExpireAfterAccess(d time.Duration) Option {
 // given duration from creation.
 func WithExpireAfterWrite(d time.Duration) Option {
 	return func(c *localCache) {
+		c.checkDuration("WithExpireAfterWrite", d)
 		c.expireAfterWrite = d
 	}
 }
 
+// ExpiredServePolicy specifies whether a LoadingCache serves expired values.
+type ExpiredServePolicy uint8
+
+const (
+	// ServeExpiredDefault serves an expired value while reloading it
+	// asynchronously, until the entry is removed by the periodic clean up.
+	ServeExpiredDefault ExpiredServePolicy = iota
+	// ServeExpiredNever treats an expired value as absent. It is removed and
+	// the value is loaded synchronously.
+	ServeExpiredNever
+	// ServeExpiredUntilRefreshFails serves an expired value while reloading it
+	// asynchronously, and removes it if the reload fails.
+	ServeExpiredUntilRefreshFails
+	// ServeExpiredAlways keeps serving expired values while reloading them,
+	// even if reloads fail. Expired entries are not removed by the clean up,
+	// so they are only removed when evicted due to the maximum size or
+	// invalidated.
+	ServeExpiredAlways
+)
+
+// WithExpiredServePolicy returns an option which sets whether expired values
+// are served by Get and GetAndRefresh of LoadingCache.
+// The default is ServeExpiredDefault.
+func WithExpiredServePolicy(p ExpiredServePolicy) Option {
+	return func(c *localCache) {
+		c.expiredServe = p
+	}
+}
+
 // WithRefreshAfterWrite returns an option to refresh a cache entry after the
 // given duration. This option is only applicable for LoadingCache.
 func WithRefreshAfterWrite(d time.Duration) Option {
 	return func(c *localCache) {
+		c.checkDuration("WithRefreshAfterWrite", d)
 		c.refreshAfterWrite = d
 	}
 }
 
+// WithRefreshJitter returns an option which delays refresh of each entry by
+// a random duration up to the given fraction of refreshAfterWrite, chosen when
+// the entry is written, so that entries written together are not refreshed
+// at the same time. It does not affect expiration.
+func WithRefreshJitter(fraction float64) Option {
+	return func(c *localCache) {
+		c.refreshJitter = fraction
+	}
+}
+
+// WithRefreshDebounce returns an option which prevents an entry from being
+// refreshed again within the given duration since its last refresh attempt,
+// regardless of whether that attempt succeeded.
+// This option is only applicable for LoadingCache.
+func WithRefreshDebounce(d time.Duration) Option {
+	return func(c *localCache) {
+		c.refreshDebounce = d
+	}
+}
+
+// WithLoadPromiseTTL returns an option which keeps result of a successful load
+// for the given duration after it completes, so that loads of the same key
+// requested within that window reuse the result instead of calling the loader.
+// Concurrent loads of the same key always share a single loader call.
+// Reused results are not added to the cache again.
+// This option is only applicable for LoadingCache.
+func WithLoadPromiseTTL(d time.Duration) Option {
+	return func(c *localCache) {
+		c.loadPromiseTTL = d
+	}
+}
+
+// WithRefreshPredicate returns an option which only refreshes entries due for
+// refresh by WithRefreshAfterWrite if the predicate returns true for them.
+// Entries which are not refreshed still expire as usual. The predicate is
+// called from the goroutine processing cache events.
+func WithRefreshPredicate(predicate func(k Key, v Value) bool) Option {
+	return func(c *localCache) {
+		c.refreshPredicate = predicate
+	}
+}
+
+// WithErrorCacheTTL returns an option which keeps errors of failed loads for
+// the given duration, so that loads of the same key within that window return
+// the error instead of calling the loader again. Errors which are temporary,
+// i.e. implement Temporary() bool returning true like net.Error, directly or
+// wrapped, are not kept so the next load retries. Panics are not kept either.
+// This option is only applicable for LoadingCache.
+func WithErrorCacheTTL(d time.Duration) Option {
+	return func(c *localCache) {
+		c.errorCacheTTL = d
+	}
+}
+
+// WithLoadWaitTimeout returns an option which limits how long a load waits
+// for a concurrent load of the same key. After the timeout, it calls the loader
+// itself instead of waiting for the result of the other load, so a hung loader
+// does not block all loads of the key. By default, it waits indefinitely.
+// This option is only applicable for LoadingCache.
+func WithLoadWaitTimeout(d time.Duration) Option {
+	return func(c *localCache) {
+		c.loadWaitTimeout = d
+	}
+}
+
+// WithReadThrough returns an option which makes GetIfPresent load absent or
+// expired values using the loader, the same as Get. GetIfPresent returns false
+// if the loader returns an error.
+// This option is only applicable for LoadingCache.
+func WithReadThrough() Option {
+	return func(c *localCache) {
+		c.readThrough = true
+	}
+}
+
+// WithRefreshPreservesRecency returns an option which keeps position of refreshed
+// entries in the cache policy, so that a background refresh is not considered
+// as an access to the entry. Write time of the entries is still updated.
+// This option is only applicable for LoadingCache.
+func WithRefreshPreservesRecency() Option {
+	return func(c *localCache) {
+		c.refreshPreservesRecency = true
+	}
+}
+
+// WithWriteCoalesce returns an option which coalesces Puts of an existing key
+// within the given window. The new value is stored immediately, but the write
+// is applied to the policy and the insertion listener is called only once per
+// window with the latest value, so listeners are notified up to d late.
+// Puts of new keys are applied immediately.
+func WithWriteCoalesce(d time.Duration) Option {
+	return func(c *localCache) {
+		c.writeCoalesce = d
+	}
+}
+
+// WithCollapseWrites returns an option which collapses repeated Puts of the same
+// key into a single write while the previous one is still pending. This reduces
+// policy updates and insertion listener calls for write-hot keys. The listener
+// still receives the latest value.
+func WithCollapseWrites() Option {
+	return func(c *localCache) {
+		c.collapseWrites = true
+	}
+}
+
+// WithNonBlockingHits returns an option which makes reads never block on a full
+// events channel. Accesses of entries which can not be sent are dropped, so the
+// policy may see less accurate recency or frequency.
+// Dropped accesses are recorded if the stats counter implements
+// DroppedHitStatsCounter.
+func WithNonBlockingHits() Option {
+	return func(c *localCache) {
+		c.nonBlockingHits = true
+	}
+}
+
+// WithCompression returns an option which stores []byte values compressed by
+// the given algorithm, trading CPU for memory. Values are decompressed when they
+// are returned or passed to listeners. Values of other types are stored as they
+// are. PriorityFunc and checksum function given by WithIntegrityCheck receive
+// compressed values.
+// Compression ratio is recorded if the stats counter implements
+// CompressionStatsCounter.
+func WithCompression(compression Compression) Option {
+	return func(c *localCache) {
+		c.compression = compression
+	}
+}
+
+// WithValueTransform returns an option which applies transform to values
+// before they are stored by Put, PutWithTags, Update, Append, loading and
+// refreshing, e.g. to intern or canonicalize them. Listeners and loading calls
+// receive the transformed values.
+func WithValueTransform(transform func(Value) Value) Option {
+	return func(c *localCache) {
+		c.valueTransform = transform
+	}
+}
+
 // WithStatsCounter returns an option which overrides default cache stats counter.
 func WithStatsCounter(st StatsCounter) Option {
 	return func(c *localCache) {
@@ -530,26 +2787,201 @@ func WithStatsCounter(st StatsCounter) Option {
 	}
 }
 
+// WithStrictCapacity returns an option which enforces the maximum size exactly.
+// By default, Put adds a new entry to the cache immediately if it seems to be
+// within the capacity, so concurrent Puts can exceed the maximum size until
+// the policy evicts entries. In strict mode, new entries are only added by the
+// policy, which evicts at the same time, so the size is never exceeded but a new
+// entry is only visible after the policy processes it. The mode can be changed
+// at runtime by SetStrictCapacity, e.g. relaxed during a bulk import, during
+// which the size can exceed the maximum transiently.
+func WithStrictCapacity() Option {
+	return func(c *localCache) {
+		c.strictCapacity = 1
+	}
+}
+
+// WithNoStats returns an option which disables stats of the cache by using
+// NoopStatsCounter, so Stats always reports zeros.
+func WithNoStats() Option {
+	return WithStatsCounter(NoopStatsCounter{})
+}
+
+// WithWeigher returns an option which sets the function to compute weight of entries.
+// Total weight of loaded values is recorded in Stats.LoadedBytes if the stats
+// counter implements WeightStatsCounter.
+// The weigher takes precedence over values implementing Sizer, which are
+// weighed by their Size when there is no weigher.
+func WithWeigher(weigher Weigher) Option {
+	return func(c *localCache) {
+		c.weigher = weigher
+	}
+}
+
 // WithPolicy returns an option which sets cache policy associated to the given name.
-// Supported policies are: lru, slru, tinylfu.
+// Supported policies are: lru, slru, tinylfu, priority, lfu, sampled, mru.
+// Other policies can be set by WithCustomPolicy.
 func WithPolicy(name string) Option {
 	return func(c *localCache) {
 		c.policyName = name
 	}
 }
 
+// WithCustomPolicy returns an option which sets the given Policy as the cache
+// policy, overriding WithPolicy. The cache reports its policy name as "custom".
+// The policy can not be shared between caches, so NewConsistentSharded panics
+// with this option when there is more than one shard.
+// See WithCustomPolicyFactory.
+func WithCustomPolicy(policy Policy) Option {
+	return func(c *localCache) {
+		c.customPolicy = policy
+		c.newCustomPolicy = nil
+		c.policyName = "custom"
+	}
+}
+
+// WithCustomPolicyFactory returns an option like WithCustomPolicy, which creates
+// the Policy by calling newPolicy for each cache, so that it can be used for
+// shards of a sharded cache.
+func WithCustomPolicyFactory(newPolicy func() Policy) Option {
+	return func(c *localCache) {
+		c.customPolicy = nil
+		c.newCustomPolicy = newPolicy
+		c.policyName = "custom"
+	}
+}
+
+// WithPriorityFunc returns an option which sets the function computing eviction
+// priority of entries for the "priority" policy. Entries with the lowest priority
+// are evicted first, and the oldest one is evicted among those with the same priority.
+// Priority is recomputed when an entry is written or accessed.
+func WithPriorityFunc(fn PriorityFunc) Option {
+	return func(c *localCache) {
+		c.priority = fn
+	}
+}
+
+// WithSketchResetSampleFactor returns an option which scales the number of
+// additions after which the TinyLFU policy halves its frequency sketch. The
+// default is 8 times the maximum size, which is factor 1. Larger values make
+// frequency estimates more stable but slower to adapt to changes of access
+// patterns, smaller values forget history sooner.
+// This option is only applicable for "tinylfu" policy.
+func WithSketchResetSampleFactor(factor float64) Option {
+	return func(c *localCache) {
+		c.sketchSampleFactor = factor
+	}
+}
+
+// WithRefreshFunc returns an option which reloads existing entries using fn
+// instead of the loader, so it can fetch the value conditionally. The loader
+// is still used when a value is not present.
+// This option is only applicable for LoadingCache.
+func WithRefreshFunc(fn RefreshFunc) Option {
+	return func(c *localCache) {
+		c.refreshFunc = fn
+	}
+}
+
+// WithLoaderChain returns an option which loads values by trying the given
+// loaders in order until one of them returns a nil error. If all of them fail,
+// the error of the last one is returned. It replaces the loader given to
+// NewLoadingCache.
+func WithLoaderChain(loaders ...LoaderFunc) Option {
+	return func(c *localCache) {
+		c.loader = chainLoaders(loaders)
+	}
+}
+
+// chainLoaders returns a LoaderFunc trying loaders in order.
+func chainLoaders(loaders []LoaderFunc) LoaderFunc {
+	return func(k Key) (Value, error) {
+		err := errors.New("cache: no loaders")
+		for _, loader := range loaders {
+			var v Value
+			if v, err = loader(k); err == nil {
+				return v, nil
+			}
+		}
+		return nil, err
+	}
+}
+
+// WithEvictionSamples returns an option which sets the number of random entries
+// compared on eviction by "sampled" policy. The default is 5. Larger values
+// approximate LRU more closely but make eviction slower.
+func WithEvictionSamples(n int) Option {
+	return func(c *localCache) {
+		c.evictionSamples = n
+	}
+}
+
 // WithExecutor returns an option which sets executor for cache loader.
 // By default, each asynchronous reload is run in a go routine.
+// The executor runs asynchronous loads of the cache, i.e. refreshes and loads
+// of RefreshWithTimeout, and it is closed when the cache is closed.
 // This option is only applicable for LoadingCache.
 func WithExecutor(executor Executor) Option {
 	return func(c *localCache) {
 		c.exec = executor
+		c.sharedExec = false
+		c.refreshWorkers = 0
+	}
+}
+
+// WithSharedExecutor returns an option like WithExecutor, but the executor is
+// not closed when the cache is closed, so a bounded executor can be shared by
+// many caches to limit their total number of concurrent loads. The executor
+// must be safe for concurrent use, and closed by the caller after the caches.
+//
+// Only loads are run by the executor. Timers of WithWriteCoalesce,
+// WithLoadPromiseTTL and WithErrorCacheTTL, and the go routines processing
+// cache events, adapting the size by WithAdaptiveSize and calling
+// WithAsyncInsertionListener are run by the cache itself, as the timers only
+// send an event or drop a finished load, and the go routines would occupy a
+// worker for the life of the cache.
+// This option is only applicable for LoadingCache.
+func WithSharedExecutor(executor Executor) Option {
+	return func(c *localCache) {
+		c.exec = executor
+		c.sharedExec = true
+		c.refreshWorkers = 0
 	}
 }
 
-// withInsertionListener is used for testing.
-func withInsertionListener(onInsertion Func) Option {
+// WithRefreshWorkers returns an option which runs asynchronous reloads in
+// the given number of go routines instead of a go routine per reload.
+// Reloads are queued when all workers are busy, and the queued ones are
+// discarded when the cache is closed.
+// The workers are started when the cache is created. Only the last one of
+// WithRefreshWorkers, WithExecutor and WithSharedExecutor is applied.
+// It is only applicable for LoadingCache.
+func WithRefreshWorkers(n int) Option {
+	return func(c *localCache) {
+		c.exec = nil
+		c.refreshWorkers = n
+	}
+}
+
+// WithInsertionListener returns an option to set a function which is called
+// when an entry is added or its value is replaced.
+// The listener is called synchronously by the goroutine processing cache
+// events, so a slow listener delays all other cache operations.
+// See WithAsyncInsertionListener, and Cache for the methods which must not be
+// called from listeners.
+func WithInsertionListener(onInsertion Func) Option {
 	return func(c *localCache) {
 		c.onInsertion = onInsertion
 	}
 }
+
+// WithAsyncInsertionListener returns an option which calls the insertion
+// listener in a separate goroutine, queueing up to bufferSize notifications.
+// When the queue is full, notifications are dropped instead of blocking cache
+// operations, and counted in Stats.DroppedInsertions. It has no effect without
+// WithInsertionListener or if bufferSize is not positive.
+func WithAsyncInsertionListener(bufferSize int) Option {
+	return func(c *localCache) {
+		c.insertionBufSize = bufferSize
+	}
+}
@@ -2,9 +2,12 @@ package cache
 
 import (
 	"errors"
+	"fmt"
+	"math"
 	"math/rand"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -19,7 +22,7 @@ func TestCache(t *testing.T) {
 	}
 
 	wg := sync.WaitGroup{}
-	c := New(withInsertionListener(func(Key, Value) {
+	c := New(WithInsertionListener(func(Key, Value) {
 		wg.Done()
 	}))
 	defer c.Close()
@@ -44,7 +47,7 @@ func TestMaximumSize(t *testing.T) {
 	insFunc := func(k Key, v Value) {
 		wg.Done()
 	}
-	c := New(WithMaximumSize(max), withInsertionListener(insFunc)).(*localCache)
+	c := New(WithMaximumSize(max), WithInsertionListener(insFunc)).(*localCache)
 	defer c.Close()
 
 	wg.Add(max)
@@ -80,7 +83,7 @@ func TestRemovalListener(t *testing.T) {
 	}
 	max := 3
 	c := New(WithMaximumSize(max), WithRemovalListener(remFunc),
-		withInsertionListener(insFunc))
+		WithInsertionListener(insFunc))
 	defer c.Close()
 
 	wg.Add(max + 2)
@@ -117,7 +120,7 @@ func TestClose(t *testing.T) {
 	insFunc := func(Key, Value) {
 		wg.Done()
 	}
-	c := New(WithRemovalListener(remFunc), withInsertionListener(insFunc))
+	c := New(WithRemovalListener(remFunc), WithInsertionListener(insFunc))
 	n := 10
 	wg.Add(n)
 	for i := 0; i < n; i++ {
@@ -132,6 +135,252 @@ func TestClose(t *testing.T) {
 	}
 }
 
+func TestOnClose(t *testing.T) {
+	var entries []Entry
+	wg := sync.WaitGroup{}
+	insFunc := func(Key, Value) {
+		wg.Done()
+	}
+	c := New(WithOnClose(func(e []Entry) {
+		entries = e
+	}), WithInsertionListener(insFunc))
+	wg.Add(2)
+	c.Put(1, "a")
+	c.Put(2, "b")
+	wg.Wait()
+	c.Invalidate(1)
+	c.Close()
+	if len(entries) != 1 || entries[0].Key != 2 || entries[0].Value != "b" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+}
+
+func TestPin(t *testing.T) {
+	for _, p := range []string{"lru", "slru", "tinylfu", "priority"} {
+		t.Run(p, func(t *testing.T) {
+			max := 3
+			wg := sync.WaitGroup{}
+			insFunc := func(k Key, v Value) {
+				wg.Done()
+			}
+			c := New(WithMaximumSize(max), WithPolicy(p), WithInsertionListener(insFunc)).(*localCache)
+			defer c.Close()
+
+			wg.Add(1)
+			c.Put(0, 0)
+			wg.Wait()
+			c.Pin(0)
+			for i := 1; i < 10; i++ {
+				wg.Add(1)
+				c.Put(i, i)
+				wg.Wait()
+			}
+			if c.cache.get(0, sum(0)) == nil {
+				t.Fatalf("pinned entry must not be evicted")
+			}
+			if n := cacheSize(&c.cache); n != max {
+				t.Fatalf("unexpected cache size: %d, want: %d", n, max)
+			}
+			c.Unpin(0)
+			for i := 10; i < 20; i++ {
+				wg.Add(1)
+				c.Put(i, i)
+				wg.Wait()
+			}
+			if c.cache.get(0, sum(0)) != nil {
+				t.Fatalf("unpinned entry must be evicted")
+			}
+		})
+	}
+}
+
+func TestPinAll(t *testing.T) {
+	for _, p := range []string{"lru", "slru", "tinylfu", "priority", "lfu", "sampled", "mru"} {
+		t.Run(p, func(t *testing.T) {
+			wg := sync.WaitGroup{}
+			insFunc := func(k Key, v Value) {
+				wg.Done()
+			}
+			c := New(WithMaximumSize(2), WithPolicy(p), WithInsertionListener(insFunc)).(*localCache)
+			defer c.Close()
+
+			for i := 0; i < 2; i++ {
+				wg.Add(1)
+				c.Put(i, i)
+				wg.Wait()
+				c.Pin(i)
+			}
+			// The new entry is evicted as the others are pinned.
+			wg.Add(1)
+			c.Put(2, 2)
+			wg.Wait()
+			if c.cache.get(0, sum(0)) == nil || c.cache.get(1, sum(1)) == nil || c.cache.get(2, sum(2)) != nil {
+				t.Fatalf("unexpected cache entries")
+			}
+			if n := c.cache.len(); n != 2 {
+				t.Fatalf("unexpected cache size: %d", n)
+			}
+		})
+	}
+}
+
+func TestGetOrSet(t *testing.T) {
+	var count int32
+	start := make(chan struct{})
+	factory := func() Value {
+		<-start
+		return atomic.AddInt32(&count, 1)
+	}
+	c := New()
+	defer c.Close()
+
+	const n = 10
+	var wg sync.WaitGroup
+	var started int32
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			atomic.AddInt32(&started, 1)
+			v := c.GetOrSet(1, factory)
+			if v.(int32) != 1 {
+				t.Errorf("unexpected value: %v", v)
+			}
+		}()
+	}
+	for atomic.LoadInt32(&started) < n {
+		runtime.Gosched()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(start)
+	wg.Wait()
+	// Wait until the value is added.
+	c.(*localCache).call(func() {})
+	v := c.GetOrSet(1, factory)
+	if v.(int32) != 1 || atomic.LoadInt32(&count) != 1 {
+		t.Fatalf("unexpected value: %v, count: %v", v, count)
+	}
+}
+
+func TestGetOrSetNotSharedWithLoad(t *testing.T) {
+	release := make(chan struct{})
+	c := NewLoadingCache(func(k Key) (Value, error) {
+		<-release
+		return "loaded", nil
+	}).(*localCache)
+	defer c.Close()
+
+	done := make(chan Value)
+	go func() {
+		v, _ := c.Get(1)
+		done <- v
+	}()
+	for i := 0; i < 100; i++ {
+		c.loadMu.Lock()
+		_, ok := c.loads[1]
+		c.loadMu.Unlock()
+		if ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// GetOrSet does not wait for the load in flight.
+	if v := c.GetOrSet(1, func() Value { return "set" }); v != "set" {
+		t.Fatalf("unexpected value: %v", v)
+	}
+	close(release)
+	if v := <-done; v != "loaded" {
+		t.Fatalf("unexpected value: %v", v)
+	}
+	c.loadMu.Lock()
+	n := len(c.factoryCalls)
+	c.loadMu.Unlock()
+	if n != 0 {
+		t.Fatalf("unexpected factory calls: %d", n)
+	}
+}
+
+func TestCloseWithTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	var removed int32
+	remFunc := func(Key, Value) {
+		atomic.AddInt32(&removed, 1)
+		<-block
+	}
+	c := New(WithRemovalListener(remFunc)).(*localCache)
+	c.Put(1, 1)
+	c.Put(2, 2)
+	err := c.CloseWithTimeout(10 * time.Millisecond)
+	if err != ErrCloseTimeout {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	block <- struct{}{}
+	<-c.closed
+	if n := atomic.LoadInt32(&removed); n != 1 {
+		t.Fatalf("unexpected removed: %d", n)
+	}
+}
+
+func TestCloseAfterTimeout(t *testing.T) {
+	block := make(chan struct{})
+	insFunc := func(Key, Value) {
+		<-block
+	}
+	c := New(WithChannelBuffer(1), WithInsertionListener(insFunc)).(*localCache)
+	c.Put(1, 1)
+	// Wait for the listener to block so that the next put fills the channel.
+	for len(c.events) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	c.Put(2, 2)
+	err := c.CloseWithTimeout(10 * time.Millisecond)
+	if err != ErrCloseTimeout {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	close(block)
+	done := make(chan error)
+	go func() {
+		done <- c.Close()
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not return")
+	}
+}
+
+func TestTouchIfPresent(t *testing.T) {
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	c := New(WithExpireAfterAccess(2 * time.Second))
+	defer c.Close()
+
+	if c.TouchIfPresent(1) {
+		t.Fatalf("expect not present")
+	}
+	c.Put(1, 1)
+	mockTime.add(time.Second)
+	if !c.TouchIfPresent(1) {
+		t.Fatalf("expect present")
+	}
+	mockTime.add(time.Second)
+	if _, ok := c.GetIfPresent(1); !ok {
+		t.Fatalf("expect present")
+	}
+	var st Stats
+	c.Stats(&st)
+	if st.RequestCount() != 1 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
 func TestLoadingCache(t *testing.T) {
 	loadCount := 0
 	loader := func(k Key) (Value, error) {
@@ -145,7 +394,7 @@ func TestLoadingCache(t *testing.T) {
 	insFunc := func(Key, Value) {
 		wg.Done()
 	}
-	c := NewLoadingCache(loader, withInsertionListener(insFunc))
+	c := NewLoadingCache(loader, WithInsertionListener(insFunc))
 	defer c.Close()
 	wg.Add(1)
 	v, err := c.Get(2)
@@ -186,7 +435,7 @@ func TestCacheStats(t *testing.T) {
 	insFunc := func(Key, Value) {
 		wg.Done()
 	}
-	c := NewLoadingCache(simpleLoader, withInsertionListener(insFunc))
+	c := NewLoadingCache(simpleLoader, WithInsertionListener(insFunc))
 	defer c.Close()
 
 	wg.Add(1)
@@ -210,6 +459,125 @@ func TestCacheStats(t *testing.T) {
 	}
 }
 
+func TestCacheStatsLoadedBytes(t *testing.T) {
+	loader := func(k Key) (Value, error) {
+		return k.(string) + k.(string), nil
+	}
+	weigher := func(k Key, v Value) uint64 {
+		return uint64(len(v.(string)))
+	}
+	c := NewLoadingCache(loader, WithWeigher(weigher))
+	defer c.Close()
+
+	c.Get("a")
+	c.Get("bc")
+	var st Stats
+	c.Stats(&st)
+	if st.LoadSuccessCount != 2 || st.LoadedBytes != 6 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+type sizedValue uint64
+
+func (v sizedValue) Size() uint64 {
+	return uint64(v)
+}
+
+func TestCacheStatsSizer(t *testing.T) {
+	loader := func(k Key) (Value, error) {
+		if k == 0 {
+			return "unsized", nil
+		}
+		return sizedValue(k.(int)), nil
+	}
+	c := NewLoadingCache(loader)
+	c.Get(2)
+	c.Get(3)
+	c.Get(0)
+	var st Stats
+	c.Stats(&st)
+	c.Close()
+	if st.LoadSuccessCount != 3 || st.LoadedBytes != 5 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	// Weigher takes precedence over Sizer.
+	c = NewLoadingCache(loader, WithWeigher(func(k Key, v Value) uint64 {
+		return 10
+	}))
+	defer c.Close()
+	c.Get(2)
+	c.Stats(&st)
+	if st.LoadedBytes != 10 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestReadThrough(t *testing.T) {
+	loader := func(k Key) (Value, error) {
+		if k.(int) < 0 {
+			return nil, errors.New("negative")
+		}
+		return k, nil
+	}
+	c := NewLoadingCache(loader, WithReadThrough())
+	defer c.Close()
+
+	v, ok := c.GetIfPresent(1)
+	if !ok || v.(int) != 1 {
+		t.Fatalf("unexpected get: %v %v", v, ok)
+	}
+	v, ok = c.GetIfPresent(-1)
+	if ok {
+		t.Fatalf("unexpected get: %v %v", v, ok)
+	}
+	var st Stats
+	c.Stats(&st)
+	if st.LoadSuccessCount != 1 || st.LoadErrorCount != 1 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestIntegrityCheck(t *testing.T) {
+	type value struct {
+		n int
+	}
+	checksum := func(v Value) uint64 {
+		return uint64(v.(*value).n)
+	}
+	var errKey Key
+	var errValue error
+	wg := sync.WaitGroup{}
+	fn := func(Key, Value) {
+		wg.Done()
+	}
+	c := New(WithIntegrityCheck(checksum), WithErrorHandler(func(k Key, err error) {
+		errKey, errValue = k, err
+	}), WithInsertionListener(fn), WithRemovalListener(fn))
+	defer c.Close()
+
+	v := &value{1}
+	wg.Add(1)
+	c.Put(1, v)
+	wg.Wait()
+	if _, ok := c.GetIfPresent(1); !ok {
+		t.Fatalf("expect present")
+	}
+	// Modify value in place.
+	wg.Add(1)
+	v.n = 2
+	if _, ok := c.GetIfPresent(1); ok {
+		t.Fatalf("expect not present")
+	}
+	wg.Wait()
+	if errKey != 1 || errValue != ErrChecksumMismatch {
+		t.Fatalf("unexpected error: %v %v", errKey, errValue)
+	}
+	if _, ok := c.GetIfPresent(1); ok {
+		t.Fatalf("expect not present")
+	}
+}
+
 func TestExpireAfterAccess(t *testing.T) {
 	wg := sync.WaitGroup{}
 	fn := func(k Key, v Value) {
@@ -218,7 +586,7 @@ func TestExpireAfterAccess(t *testing.T) {
 	mockTime := newMockTime()
 	currentTime = mockTime.now
 	c := New(WithExpireAfterAccess(1*time.Second), WithRemovalListener(fn),
-		withInsertionListener(fn)).(*localCache)
+		WithInsertionListener(fn)).(*localCache)
 	defer c.Close()
 
 	wg.Add(1)
@@ -251,6 +619,117 @@ func TestExpireAfterAccess(t *testing.T) {
 	}
 }
 
+func TestBatchExpiryListener(t *testing.T) {
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	var batches [][]Entry
+	c := New(WithExpireAfterWrite(time.Second), WithBatchExpiryListener(func(entries []Entry) {
+		batches = append(batches, entries)
+	})).(*localCache)
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		c.Put(i, i)
+	}
+	c.call(func() {})
+	mockTime.add(2 * time.Second)
+	c.Put(3, 3)
+	c.call(func() {
+		if len(batches) != 1 || len(batches[0]) != 3 {
+			t.Fatalf("unexpected batches: %v", batches)
+		}
+		for i, en := range batches[0] {
+			if en.Key != i || en.Value != i {
+				t.Fatalf("unexpected expired entry: %+v", en)
+			}
+		}
+	})
+}
+
+func TestLazyExpiryOnly(t *testing.T) {
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	c := New(WithExpireAfterWrite(time.Second), WithLazyExpiryOnly()).(*localCache)
+	defer c.Close()
+
+	c.Put(1, 1)
+	c.Put(2, 2)
+	c.call(func() {})
+	mockTime.add(2 * time.Second)
+	c.Put(3, 3)
+	c.call(func() {})
+	if n := c.cache.len(); n != 3 {
+		t.Fatalf("unexpected cache size: %d", n)
+	}
+	if _, ok := c.GetIfPresent(1); ok {
+		t.Fatal("unexpected expired entry")
+	}
+	c.call(func() {})
+	if n := c.cache.len(); n != 2 {
+		t.Fatalf("unexpected cache size: %d", n)
+	}
+}
+
+func TestExpiredCountEstimate(t *testing.T) {
+	wg := sync.WaitGroup{}
+	fn := func(k Key, v Value) {
+		wg.Done()
+	}
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	c := New(WithExpireAfterAccess(1*time.Second), WithInsertionListener(fn))
+	defer c.Close()
+
+	wg.Add(3)
+	c.Put(1, 1)
+	c.Put(2, 2)
+	c.Put(3, 3)
+	wg.Wait()
+	if n := c.ExpiredCountEstimate(); n != 0 {
+		t.Fatalf("unexpected expired count: %d", n)
+	}
+	mockTime.add(2 * time.Second)
+	if n := c.ExpiredCountEstimate(); n != 3 {
+		t.Fatalf("unexpected expired count: %d", n)
+	}
+}
+
+func TestExpireEntriesBurst(t *testing.T) {
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	c := New(WithExpireAfterWrite(1 * time.Second))
+	defer c.Close()
+	l := c.(*localCache)
+
+	for i := 0; i < 100; i++ {
+		c.Put(i, i)
+	}
+	l.call(func() {})
+	mockTime.add(2 * time.Second)
+	// Drain limit is doubled while all drained entries were expired.
+	for _, remain := range []int{84, 52, 0, 0} {
+		l.call(l.expireEntries)
+		if n := cacheSize(&l.cache); n != remain {
+			t.Fatalf("unexpected cache size: %d, want: %d", n, remain)
+		}
+	}
+	if l.drainLimit != drainMax {
+		t.Fatalf("unexpected drain limit: %d", l.drainLimit)
+	}
+}
+
 func TestExpireAfterWrite(t *testing.T) {
 	loadCount := 0
 	loader := func(k Key) (Value, error) {
@@ -264,7 +743,7 @@ func TestExpireAfterWrite(t *testing.T) {
 	mockTime := newMockTime()
 	currentTime = mockTime.now
 	c := NewLoadingCache(loader, WithExpireAfterWrite(1*time.Second),
-		withInsertionListener(insFunc))
+		WithInsertionListener(insFunc))
 	defer c.Close()
 	// New value
 	wg.Add(1)
@@ -324,7 +803,7 @@ func TestRefreshAterWrite(t *testing.T) {
 	mockTime := newMockTime()
 	currentTime = mockTime.now
 	c := NewLoadingCache(loader, WithExpireAfterAccess(4*time.Second), WithRefreshAfterWrite(2*time.Second),
-		WithExecutor(syncExecutor{}), withInsertionListener(insFunc))
+		WithExecutor(syncExecutor{}), WithInsertionListener(insFunc))
 	defer c.Close()
 
 	wg.Add(3)
@@ -355,12 +834,47 @@ func TestRefreshAterWrite(t *testing.T) {
 	}
 }
 
+func TestRefreshPreservesRecency(t *testing.T) {
+	for _, preserve := range []bool{false, true} {
+		wg := sync.WaitGroup{}
+		insFunc := func(Key, Value) {
+			wg.Done()
+		}
+		options := []Option{WithMaximumSize(2), WithPolicy("lru"),
+			WithExecutor(syncExecutor{}), WithInsertionListener(insFunc)}
+		if preserve {
+			options = append(options, WithRefreshPreservesRecency())
+		}
+		c := NewLoadingCache(simpleLoader, options...)
+
+		wg.Add(3)
+		c.Put(1, 1)
+		c.Put(2, 2)
+		c.Refresh(1)
+		wg.Wait()
+		wg.Add(1)
+		c.Put(3, 3)
+		wg.Wait()
+		// Without preserving recency, key 2 is evicted as key 1 was refreshed.
+		keys := c.EvictionOrder(2)
+		want := Key(1)
+		if preserve {
+			want = 2
+		}
+		if len(keys) != 2 || keys[0] != want || keys[1] != 3 {
+			t.Fatalf("unexpected keys: %v, want: %v", keys, want)
+		}
+		c.Close()
+	}
+}
+
 func TestGetIfPresentExpired(t *testing.T) {
 	wg := sync.WaitGroup{}
 	insFunc := func(Key, Value) {
 		wg.Done()
 	}
-	c := New(WithExpireAfterWrite(1*time.Second), withInsertionListener(insFunc))
+	c := New(WithExpireAfterWrite(1*time.Second), WithInsertionListener(insFunc))
+	defer c.Close()
 	mockTime := newMockTime()
 	currentTime = mockTime.now
 
@@ -392,6 +906,7 @@ func TestSynchronousReload(t *testing.T) {
 		return val, nil
 	}
 	c := NewLoadingCache(loader, WithExpireAfterWrite(1*time.Second), WithExecutor(syncExecutor{}))
+	defer c.Close()
 	val = "a"
 	v, err := c.Get(1)
 	if err != nil || v != val {
@@ -409,42 +924,1943 @@ func TestSynchronousReload(t *testing.T) {
 	}
 }
 
-func TestCloseMultiple(t *testing.T) {
-	c := New()
-	start := make(chan bool)
-	const n = 10
-	var wg sync.WaitGroup
-	wg.Add(n)
-	for i := 0; i < n; i++ {
-		go func() {
-			defer wg.Done()
-			<-start
-			c.Close()
-		}()
+func TestGetAndRefresh(t *testing.T) {
+	var loadCount int32
+	loader := func(k Key) (Value, error) {
+		return int(atomic.AddInt32(&loadCount, 1)), nil
 	}
-	close(start)
-	wg.Wait()
-	// Should not panic
-	c.GetIfPresent(0)
-	c.Put(1, 1)
-	c.Invalidate(0)
-	c.InvalidateAll()
-	c.Close()
-}
-
-func BenchmarkExpireLRUAfterWrite(b *testing.B) {
-	b.ReportAllocs()
-	// mockTime := newMockTime()
-	// currentTime = mockTime.now
-	c := New(
-		WithExpireAfterWrite(10*time.Microsecond),
-		WithPolicy("lru"),
-		// WithMaximumSize(100),
-	)
+	wg := sync.WaitGroup{}
+	insFunc := func(Key, Value) {
+		wg.Done()
+	}
+	c := NewLoadingCache(loader, WithInsertionListener(insFunc))
 	defer c.Close()
 
-	type value struct {
-		ii float64
+	wg.Add(1)
+	v, err := c.GetAndRefresh(1)
+	if err != nil || v.(int) != 1 {
+		t.Fatalf("unexpected get: %v %v", v, err)
+	}
+	wg.Wait()
+	wg.Add(1)
+	v, err = c.GetAndRefresh(1)
+	if err != nil || v.(int) != 1 {
+		t.Fatalf("unexpected get: %v %v", v, err)
+	}
+	wg.Wait()
+	v, err = c.Get(1)
+	if err != nil || v.(int) != 2 {
+		t.Fatalf("unexpected get: %v %v", v, err)
+	}
+}
+
+func TestPutAfterInvalidate(t *testing.T) {
+	removed := 0
+	wg := sync.WaitGroup{}
+	remFunc := func(Key, Value) {
+		removed++
+	}
+	insFunc := func(Key, Value) {
+		wg.Done()
+	}
+	c := New(WithRemovalListener(remFunc), WithInsertionListener(insFunc)).(*localCache)
+	defer c.Close()
+
+	wg.Add(1)
+	c.Put(1, 1)
+	wg.Wait()
+	for i := 2; i < 100; i++ {
+		wg.Add(1)
+		c.Invalidate(1)
+		c.Put(1, i)
+		wg.Wait()
+		v, ok := c.GetIfPresent(1)
+		if !ok || v.(int) != i {
+			t.Fatalf("unexpected get: %v %v, want: %v", v, ok, i)
+		}
+	}
+	c.call(func() {})
+	if removed != 0 {
+		t.Fatalf("unexpected removed: %d", removed)
+	}
+}
+
+func TestOperationObserver(t *testing.T) {
+	var mu sync.Mutex
+	ops := map[string]int{}
+	observe := func(op string, d time.Duration) {
+		mu.Lock()
+		ops[op]++
+		mu.Unlock()
+	}
+	c := NewLoadingCache(simpleLoader, WithOperationObserver(observe))
+	defer c.Close()
+
+	c.Put(1, 1)
+	c.GetIfPresent(1)
+	c.Get(2)
+	c.Invalidate(1)
+	mu.Lock()
+	defer mu.Unlock()
+	for _, op := range []string{"Put", "GetIfPresent", "Get", "Invalidate"} {
+		if ops[op] != 1 {
+			t.Fatalf("unexpected operations: %v", ops)
+		}
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	c := Disabled()
+	c.Put(1, 1)
+	if _, ok := c.GetIfPresent(1); ok {
+		t.Fatalf("nil cache must always miss")
+	}
+	if v := c.GetOrSet(1, func() Value { return 2 }); v != 2 {
+		t.Fatalf("unexpected value: %v", v)
+	}
+	if _, err := c.Get(1); err != ErrNilCache {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var st Stats
+	c.Stats(&st)
+	c.Invalidate(1)
+	c.InvalidateAll()
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMaximumSizeOne(t *testing.T) {
+	for _, policy := range []string{"lru", "slru", "tinylfu", "lfu", "sampled", "mru"} {
+		c := New(WithMaximumSize(1), WithPolicy(policy))
+		l := c.(*localCache)
+		c.Put(1, 1)
+		l.call(func() {})
+		c.GetIfPresent(1)
+		c.Put(2, 2)
+		l.call(func() {})
+		if n := cacheSize(&l.cache); n != 1 {
+			t.Fatalf("%s: unexpected cache size: %d", policy, n)
+		}
+		if _, ok := c.GetIfPresent(2); !ok {
+			t.Fatalf("%s: most recent entry must be kept", policy)
+		}
+		c.Close()
+	}
+}
+
+func TestGetWithRefreshCallback(t *testing.T) {
+	loadCount := 0
+	loader := func(k Key) (Value, error) {
+		loadCount++
+		return loadCount, nil
+	}
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	c := NewLoadingCache(loader, WithExpireAfterWrite(1*time.Second),
+		WithExecutor(syncExecutor{}))
+	defer c.Close()
+
+	called := 0
+	onRefreshed := func(v Value, err error) {
+		called++
+		if v != 2 || err != nil {
+			t.Fatalf("unexpected refresh result: %v %v", v, err)
+		}
+	}
+	// Loaded synchronously without calling back.
+	if v, err := c.GetWithRefreshCallback(1, onRefreshed); v != 1 || err != nil || called != 0 {
+		t.Fatalf("unexpected get: %v %v %d", v, err, called)
+	}
+	c.(*localCache).call(func() {})
+	mockTime.add(2 * time.Second)
+	// The callback is called after reloading, which is synchronous here.
+	if _, err := c.GetWithRefreshCallback(1, onRefreshed); err != nil || called != 1 {
+		t.Fatalf("unexpected get: %v %d", err, called)
+	}
+	// Nothing is reloaded.
+	if _, err := c.GetWithRefreshCallback(1, onRefreshed); err != nil || called != 1 {
+		t.Fatalf("unexpected get: %v %d", err, called)
+	}
+}
+
+func TestRefreshPredicate(t *testing.T) {
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	c := NewLoadingCache(simpleLoader, WithRefreshAfterWrite(time.Second),
+		WithRefreshPredicate(func(k Key, v Value) bool {
+			return k.(int)%2 == 0
+		}))
+	defer c.Close()
+	l := c.(*localCache)
+
+	c.Put(1, 1)
+	c.Put(2, 2)
+	l.call(func() {})
+	mockTime.add(2 * time.Second)
+	if l.needRefresh(l.cache.get(1, sum(1)), mockTime.now()) {
+		t.Fatal("unexpected refresh of rejected entry")
+	}
+	if !l.needRefresh(l.cache.get(2, sum(2)), mockTime.now()) {
+		t.Fatal("expected refresh of approved entry")
+	}
+}
+
+func TestRefreshPredicateLimit(t *testing.T) {
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	calls := 0
+	c := NewLoadingCache(simpleLoader, WithRefreshAfterWrite(time.Second),
+		WithRefreshPredicate(func(k Key, v Value) bool {
+			calls++
+			return false
+		}))
+	defer c.Close()
+	l := c.(*localCache)
+
+	for i := 0; i < 100; i++ {
+		c.Put(i, i)
+	}
+	mockTime.add(2 * time.Second)
+	l.call(func() {
+		calls = 0
+		l.expireEntries()
+	})
+	if calls != drainMax {
+		t.Fatalf("unexpected predicate calls: %d", calls)
+	}
+}
+
+func TestRefreshJitter(t *testing.T) {
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	c := NewLoadingCache(simpleLoader, WithRefreshAfterWrite(1*time.Second),
+		WithRefreshJitter(0.5))
+	defer c.Close()
+	l := c.(*localCache)
+
+	c.Put(1, 1)
+	l.call(func() {})
+	en := l.cache.get(1, sum(1))
+	if j := en.getRefreshJitter(); j < 0 || j > int64(500*time.Millisecond) {
+		t.Fatalf("unexpected jitter: %v", j)
+	}
+	en.setRefreshJitter(int64(300 * time.Millisecond))
+	mockTime.add(1200 * time.Millisecond)
+	if l.needRefresh(en, mockTime.now()) {
+		t.Fatalf("refresh must be delayed by jitter")
+	}
+	mockTime.add(200 * time.Millisecond)
+	if !l.needRefresh(en, mockTime.now()) {
+		t.Fatalf("entry must need refresh")
+	}
+}
+
+func TestLoaderChain(t *testing.T) {
+	errLocal := errors.New("local")
+	errRemote := errors.New("remote")
+	local := func(k Key) (Value, error) {
+		if k == 1 {
+			return "local", nil
+		}
+		return nil, errLocal
+	}
+	remote := func(k Key) (Value, error) {
+		if k == 3 {
+			return nil, errRemote
+		}
+		return "remote", nil
+	}
+	c := NewLoadingCache(nil, WithLoaderChain(local, remote))
+	defer c.Close()
+
+	if v, err := c.Get(1); v != "local" || err != nil {
+		t.Fatalf("unexpected get: %v %v", v, err)
+	}
+	if v, err := c.Get(2); v != "remote" || err != nil {
+		t.Fatalf("unexpected get: %v %v", v, err)
+	}
+	if _, err := c.Get(3); err != errRemote {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestPolicyName(t *testing.T) {
+	c := New()
+	defer c.Close()
+	if n := c.PolicyName(); n != "slru" {
+		t.Fatalf("unexpected policy: %s", n)
+	}
+	c2 := New(WithPolicy("lru"))
+	defer c2.Close()
+	if n := c2.PolicyName(); n != "lru" {
+		t.Fatalf("unexpected policy: %s", n)
+	}
+}
+
+func TestListenerOrder(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	record := func(event string) Func {
+		return func(k Key, v Value) {
+			mu.Lock()
+			events = append(events, fmt.Sprintf("%s %v", event, k))
+			mu.Unlock()
+		}
+	}
+	c := New(WithMaximumSize(1), WithPolicy("lru"),
+		WithRemovalListener(record("remove")), WithInsertionListener(record("insert")))
+	defer c.Close()
+
+	c.Put(1, 1)
+	c.Put(2, 2)
+	c.(*localCache).call(func() {})
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"insert 1", "remove 1", "insert 2"}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Fatalf("unexpected events: %v, want: %v", events, want)
+	}
+}
+
+func TestValueTransform(t *testing.T) {
+	double := func(v Value) Value {
+		return v.(int) * 2
+	}
+	var inserted int32
+	insFunc := func(k Key, v Value) {
+		atomic.StoreInt32(&inserted, int32(v.(int)))
+	}
+	c := NewLoadingCache(func(k Key) (Value, error) {
+		return k, nil
+	}, WithValueTransform(double), WithInsertionListener(insFunc))
+	defer c.Close()
+
+	c.Put(1, 1)
+	if v, _ := c.GetIfPresent(1); v != 2 {
+		t.Fatalf("unexpected value: %v", v)
+	}
+	c.(*localCache).call(func() {})
+	if n := atomic.LoadInt32(&inserted); n != 2 {
+		t.Fatalf("unexpected inserted value: %d", n)
+	}
+	if v, _ := c.Get(3); v != 6 {
+		t.Fatalf("unexpected value: %v", v)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	var removed int32
+	c := New(WithRemovalListener(func(Key, Value) {
+		atomic.AddInt32(&removed, 1)
+	}))
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		c.Put(i, i)
+	}
+	c.(*localCache).call(func() {})
+	entries := c.Drain()
+	if len(entries) != 3 || entries[0] != 0 || entries[2] != 2 {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+	if n := atomic.LoadInt32(&removed); n != 3 {
+		t.Fatalf("unexpected removed: %d", n)
+	}
+	if _, ok := c.GetIfPresent(1); ok {
+		t.Fatalf("entry must be removed")
+	}
+}
+
+func TestPutWithTimes(t *testing.T) {
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	c := New(WithExpireAfterWrite(2 * time.Second))
+	defer c.Close()
+
+	now := mockTime.now()
+	c.PutWithTimes(1, 1, now.Add(-1500*time.Millisecond), now)
+	// Future times are replaced with now.
+	c.PutWithTimes(2, 2, now.Add(time.Hour), now.Add(time.Hour))
+	if _, ok := c.GetIfPresent(1); !ok {
+		t.Fatalf("entry must be present")
+	}
+	mockTime.add(1 * time.Second)
+	if _, ok := c.GetIfPresent(1); ok {
+		t.Fatalf("entry must be expired")
+	}
+	if _, ok := c.GetIfPresent(2); !ok {
+		t.Fatalf("entry must be present")
+	}
+	mockTime.add(1500 * time.Millisecond)
+	if _, ok := c.GetIfPresent(2); ok {
+		t.Fatalf("entry must be expired")
+	}
+}
+
+func TestPutWithTimesExpireUnread(t *testing.T) {
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	var removed []Key
+	c := New(WithExpireAfterWrite(2*time.Second), WithRemovalListener(func(k Key, v Value) {
+		removed = append(removed, k)
+	}))
+	defer c.Close()
+	l := c.(*localCache)
+
+	now := mockTime.now()
+	c.Put(1, 1)
+	c.PutWithTimes(2, 2, now.Add(-1500*time.Millisecond), now)
+	mockTime.add(1 * time.Second)
+	// The imported entry is swept by the clean up after the next write
+	// although it is behind a fresh one.
+	c.Put(3, 3)
+	l.call(func() {})
+	if len(removed) != 1 || removed[0] != 2 {
+		t.Fatalf("unexpected removed entries: %v", removed)
+	}
+	if n := cacheSize(&l.cache); n != 2 {
+		t.Fatalf("unexpected cache size: %d", n)
+	}
+}
+
+func TestRefreshFunc(t *testing.T) {
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	refreshFunc := func(k Key, old Value, writtenAt time.Time) (Value, error) {
+		if old == 1 {
+			return nil, ErrNotModified
+		}
+		return 10, nil
+	}
+	c := NewLoadingCache(simpleLoader, WithExpireAfterWrite(1*time.Second),
+		WithRefreshFunc(refreshFunc), WithExecutor(syncExecutor{}))
+	defer c.Close()
+	l := c.(*localCache)
+
+	c.Put(1, 1)
+	c.Put(2, 2)
+	l.call(func() {})
+	mockTime.add(1500 * time.Millisecond)
+	c.Refresh(1)
+	c.Refresh(2)
+	l.call(func() {})
+	mockTime.add(500 * time.Millisecond)
+	// Write time of the not modified entry is reset.
+	if v, ok := c.GetIfPresent(1); !ok || v != 1 {
+		t.Fatalf("unexpected value: %v %v", v, ok)
+	}
+	if v, ok := c.GetIfPresent(2); !ok || v != 10 {
+		t.Fatalf("unexpected value: %v %v", v, ok)
+	}
+}
+
+func TestBackpressureObserver(t *testing.T) {
+	var mu sync.Mutex
+	var observed []string
+	c := New(WithBackpressureObserver(time.Millisecond, func(ev string, d time.Duration) {
+		if d < time.Millisecond {
+			t.Errorf("unexpected blocked duration: %v", d)
+		}
+		mu.Lock()
+		observed = append(observed, ev)
+		mu.Unlock()
+	}))
+	defer c.Close()
+	l := c.(*localCache)
+
+	// Block processEntries goroutine and fill the events channel.
+	release := make(chan struct{})
+	l.enqueue(entryEvent{event: eventCall, fn: func() { <-release }})
+	for i := 0; i < chanBufSize; i++ {
+		c.Put(i, i)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+	c.Put(chanBufSize, 0)
+	l.call(func() {})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(observed) == 0 || observed[0] != "write" {
+		t.Fatalf("unexpected observed events: %v", observed)
+	}
+}
+
+func TestChannelBuffer(t *testing.T) {
+	c := New(WithChannelBuffer(128))
+	defer c.Close()
+	if n := cap(c.(*localCache).events); n != 128 {
+		t.Fatalf("unexpected buffer size: %d", n)
+	}
+	c = New(WithChannelBuffer(-1))
+	defer c.Close()
+	if n := cap(c.(*localCache).events); n != chanBufSize {
+		t.Fatalf("unexpected buffer size: %d", n)
+	}
+}
+
+func TestGetFresh(t *testing.T) {
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	var loads int32
+	c := NewLoadingCache(func(k Key) (Value, error) {
+		return atomic.AddInt32(&loads, 1), nil
+	})
+	defer c.Close()
+	l := c.(*localCache)
+
+	v, err := c.GetFresh(1, time.Minute)
+	if err != nil || v != int32(1) {
+		t.Fatalf("unexpected get: %v %v", v, err)
+	}
+	l.call(func() {})
+	mockTime.add(30 * time.Second)
+	v, err = c.GetFresh(1, time.Minute)
+	if err != nil || v != int32(1) {
+		t.Fatalf("unexpected get: %v %v", v, err)
+	}
+	v, err = c.GetFresh(1, 10*time.Second)
+	if err != nil || v != int32(2) {
+		t.Fatalf("unexpected get: %v %v", v, err)
+	}
+	l.call(func() {})
+	v, err = c.Get(1)
+	if err != nil || v != int32(2) {
+		t.Fatalf("unexpected get: %v %v", v, err)
+	}
+	var st Stats
+	c.Stats(&st)
+	if st.HitCount != 2 || st.MissCount != 2 {
+		t.Fatalf("unexpected stats: %v", &st)
+	}
+}
+
+func TestLoaderPanicRecovery(t *testing.T) {
+	var reported error
+	c := NewLoadingCache(func(k Key) (Value, error) {
+		panic("boom")
+	}, WithLoaderPanicRecovery(), WithErrorHandler(func(k Key, err error) {
+		reported = err
+	}))
+	defer c.Close()
+
+	_, err := c.Get(1)
+	if !errors.Is(err, ErrLoaderPanic) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reported != err {
+		t.Fatalf("unexpected reported error: %v", reported)
+	}
+	var st Stats
+	c.Stats(&st)
+	if st.LoadErrorCount != 1 {
+		t.Fatalf("unexpected stats: %v", &st)
+	}
+}
+
+func TestLoadFallback(t *testing.T) {
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	var fail int32
+	c := NewLoadingCache(func(k Key) (Value, error) {
+		switch atomic.LoadInt32(&fail) {
+		case 1:
+			return nil, errors.New("failed")
+		case 2:
+			panic("boom")
+		}
+		return k, nil
+	}, WithLoadFallback(func(k Key) Value {
+		return -1
+	}), WithRefreshAfterWrite(time.Minute), WithExecutor(syncExecutor{}))
+	defer c.Close()
+	l := c.(*localCache)
+
+	atomic.StoreInt32(&fail, 1)
+	if v, err := c.Get(1); err != nil || v != -1 {
+		t.Fatalf("unexpected get: %v %v", v, err)
+	}
+	atomic.StoreInt32(&fail, 2)
+	if v, err := c.Get(1); err != nil || v != -1 {
+		t.Fatalf("unexpected get: %v %v", v, err)
+	}
+	// Fallback values are not cached.
+	atomic.StoreInt32(&fail, 0)
+	if v, err := c.Get(1); err != nil || v != 1 {
+		t.Fatalf("unexpected get: %v %v", v, err)
+	}
+	l.call(func() {})
+	// A panicked refresh keeps the current value.
+	atomic.StoreInt32(&fail, 2)
+	mockTime.add(2 * time.Minute)
+	if v, err := c.Get(1); err != nil || v != 1 {
+		t.Fatalf("unexpected get: %v %v", v, err)
+	}
+	l.call(func() {})
+}
+
+func TestLoadingKeys(t *testing.T) {
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	var blocking int32
+	c := NewLoadingCache(func(k Key) (Value, error) {
+		if atomic.LoadInt32(&blocking) != 0 {
+			started <- struct{}{}
+			<-release
+		}
+		return k, nil
+	}, WithExpireAfterWrite(time.Minute))
+	defer c.Close()
+	l := c.(*localCache)
+
+	c.Get(1)
+	l.call(func() {})
+	if keys := c.LoadingKeys(); len(keys) != 0 {
+		t.Fatalf("unexpected loading keys: %v", keys)
+	}
+	atomic.StoreInt32(&blocking, 1)
+	mockTime.add(2 * time.Minute)
+	// Refreshing key 1 in background and loading key 2.
+	c.Get(1)
+	done := make(chan struct{})
+	go func() {
+		c.Get(2)
+		close(done)
+	}()
+	<-started
+	<-started
+	keys := c.LoadingKeys()
+	if len(keys) != 2 || keys[0] == keys[1] {
+		t.Fatalf("unexpected loading keys: %v", keys)
+	}
+	close(release)
+	<-done
+	l.call(func() {})
+	for c.LoadingKeys() != nil {
+		time.Sleep(time.Millisecond)
+	}
+	l.call(func() {})
+}
+
+func TestEmptyStateListener(t *testing.T) {
+	var states []bool
+	c := New(WithEmptyStateListener(func(empty bool) {
+		states = append(states, empty)
+	}))
+	l := c.(*localCache)
+
+	c.Put(1, 1)
+	c.Put(2, 2)
+	l.call(func() {})
+	c.Invalidate(1)
+	l.call(func() {})
+	c.Invalidate(2)
+	l.call(func() {})
+	c.Put(3, 3)
+	c.Close()
+	if len(states) != 4 || states[0] || !states[1] || states[2] || !states[3] {
+		t.Fatalf("unexpected states: %v", states)
+	}
+}
+
+func TestClear(t *testing.T) {
+	removed := 0
+	for _, policy := range []string{"lru", "slru", "tinylfu"} {
+		c := New(WithPolicy(policy), WithMaximumSize(10), WithRemovalListener(func(Key, Value) {
+			removed++
+		}))
+		for i := 0; i < 5; i++ {
+			c.Put(i, i)
+		}
+		c.Clear(false)
+		if n := cacheSize(&c.(*localCache).cache); n != 0 || removed != 0 {
+			t.Fatalf("%s: unexpected size: %d, removed: %d", policy, n, removed)
+		}
+		// The cache is still usable.
+		for i := 0; i < 20; i++ {
+			c.Put(i, i)
+		}
+		c.Clear(true)
+		if n := cacheSize(&c.(*localCache).cache); n != 0 || removed != 20 {
+			t.Fatalf("%s: unexpected size: %d, removed: %d", policy, n, removed)
+		}
+		c.Put(1, 1)
+		c.(*localCache).call(func() {})
+		if _, ok := c.GetIfPresent(1); !ok {
+			t.Fatalf("%s: expect present", policy)
+		}
+		c.Close()
+		removed = 0
+	}
+}
+
+func TestExpiredServePolicy(t *testing.T) {
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	var fail int32
+	var loads int32
+	loader := func(k Key) (Value, error) {
+		if atomic.LoadInt32(&fail) != 0 {
+			return nil, errors.New("failed")
+		}
+		return atomic.AddInt32(&loads, 1), nil
+	}
+	newCache := func(p ExpiredServePolicy) *localCache {
+		atomic.StoreInt32(&fail, 0)
+		atomic.StoreInt32(&loads, 0)
+		c := NewLoadingCache(loader, WithExpireAfterWrite(time.Second),
+			WithExecutor(syncExecutor{}), WithExpiredServePolicy(p)).(*localCache)
+		c.Get(1)
+		c.call(func() {})
+		mockTime.add(2 * time.Second)
+		return c
+	}
+	present := func(c *localCache) bool {
+		var ok bool
+		c.call(func() {
+			ok = c.cache.get(1, sum(1)) != nil
+		})
+		return ok
+	}
+
+	c := newCache(ServeExpiredNever)
+	if v, err := c.Get(1); err != nil || v != int32(2) {
+		t.Fatalf("unexpected get: %v %v", v, err)
+	}
+	c.Close()
+
+	c = newCache(ServeExpiredUntilRefreshFails)
+	atomic.StoreInt32(&fail, 1)
+	if v, err := c.Get(1); err != nil || v != int32(1) {
+		t.Fatalf("unexpected get: %v %v", v, err)
+	}
+	if present(c) {
+		t.Fatalf("expect not present")
+	}
+	c.Close()
+
+	c = newCache(ServeExpiredAlways)
+	atomic.StoreInt32(&fail, 1)
+	for i := 0; i < 2; i++ {
+		if v, err := c.Get(1); err != nil || v != int32(1) {
+			t.Fatalf("unexpected get: %v %v", v, err)
+		}
+		// Clean up does not remove the expired entry.
+		c.Put(2, 2)
+		if !present(c) {
+			t.Fatalf("expect present")
+		}
+	}
+	c.Close()
+}
+
+func TestFind(t *testing.T) {
+	c := New()
+	defer c.Close()
+	for i := 0; i < 10; i++ {
+		c.Put(i, i)
+	}
+	even := func(k Key, v Value) bool {
+		return v.(int)%2 == 0
+	}
+	found := c.Find(even, 0)
+	if len(found) != 5 || found[4] != 4 {
+		t.Fatalf("unexpected entries: %v", found)
+	}
+	if found = c.Find(even, 2); len(found) != 2 {
+		t.Fatalf("unexpected entries: %v", found)
+	}
+	var st Stats
+	c.Stats(&st)
+	if st.RequestCount() != 0 {
+		t.Fatalf("unexpected stats: %v", &st)
+	}
+}
+
+func TestRejectNil(t *testing.T) {
+	var rejected []Key
+	l := NewLoadingCache(func(k Key) (Value, error) {
+		return nil, nil
+	}, WithRejectNil(), WithErrorHandler(func(k Key, err error) {
+		if err != ErrNilValue {
+			t.Errorf("unexpected error: %v", err)
+		}
+		rejected = append(rejected, k)
+	})).(*localCache)
+	defer l.Close()
+
+	l.Put(1, nil)
+	l.PutWithTags(2, nil, "tag")
+	v, err := l.Get(3)
+	if v != nil || err != nil {
+		t.Fatalf("unexpected get: %v %v", v, err)
+	}
+	l.Put(4, 4)
+	if l.Update(4, nil) {
+		t.Fatal("expected update of nil value rejected")
+	}
+	l.call(func() {})
+	if l.cache.len() != 1 {
+		t.Fatalf("unexpected cache size: %d", l.cache.len())
+	}
+	if v, ok := l.GetIfPresent(4); !ok || v != 4 {
+		t.Fatalf("unexpected value: %v %v", v, ok)
+	}
+	if len(rejected) != 4 {
+		t.Fatalf("unexpected rejected keys: %v", rejected)
+	}
+}
+
+func TestRangeByAccessOrder(t *testing.T) {
+	l := New(WithPolicy("lru"), WithExpireAfterAccess(time.Hour)).(*localCache)
+	defer l.Close()
+	for i := 0; i < 4; i++ {
+		l.Put(i, i*10)
+	}
+	l.call(func() {})
+	l.GetIfPresent(1)
+	l.call(func() {})
+	accessTime := l.cache.get(2, sum(2)).getAccessTime()
+
+	var keys []Key
+	l.RangeByAccessOrder(func(k Key, v Value) bool {
+		if v != k.(int)*10 {
+			t.Errorf("unexpected value of %v: %v", k, v)
+		}
+		keys = append(keys, k)
+		return len(keys) < 3
+	})
+	if len(keys) != 3 || keys[0] != 0 || keys[1] != 2 || keys[2] != 3 {
+		t.Fatalf("unexpected access order: %v", keys)
+	}
+	l.call(func() {})
+	if l.cache.get(2, sum(2)).getAccessTime() != accessTime {
+		t.Fatal("access time changed")
+	}
+}
+
+func TestRefreshWithTimeout(t *testing.T) {
+	release := make(chan struct{})
+	var loads int32
+	c := NewLoadingCache(func(k Key) (Value, error) {
+		atomic.AddInt32(&loads, 1)
+		if k == 1 {
+			<-release
+		}
+		return k, nil
+	}).(*localCache)
+	defer c.Close()
+
+	if err := c.RefreshWithTimeout(2, time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.call(func() {})
+	if v, ok := c.GetIfPresent(2); !ok || v != 2 {
+		t.Fatalf("unexpected value: %v %v", v, ok)
+	}
+	if err := c.RefreshWithTimeout(1, 10*time.Millisecond); err != ErrLoadTimeout {
+		t.Fatalf("expected timeout, actual: %v", err)
+	}
+	close(release)
+	// The load is shared with the timed out one.
+	if v, err := c.Get(1); err != nil || v != 1 {
+		t.Fatalf("unexpected get: %v %v", v, err)
+	}
+	if n := atomic.LoadInt32(&loads); n != 2 {
+		t.Fatalf("unexpected loads: %d", n)
+	}
+	// Non-positive timeout waits for the load.
+	if err := c.RefreshWithTimeout(3, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.call(func() {})
+	if v, ok := c.GetIfPresent(3); !ok || v != 3 {
+		t.Fatalf("unexpected value: %v %v", v, ok)
+	}
+
+	n := New().(*localCache)
+	defer n.Close()
+	if err := n.RefreshWithTimeout(1, time.Second); err != ErrNoLoader {
+		t.Fatalf("expected no loader error, actual: %v", err)
+	}
+}
+
+func TestNonBlockingHits(t *testing.T) {
+	c := New(WithChannelBuffer(1), WithNonBlockingHits()).(*localCache)
+	defer c.Close()
+	c.Put(1, 1)
+	c.call(func() {})
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go c.call(func() {
+		close(started)
+		<-release
+	})
+	<-started
+	for i := 0; i < 5; i++ {
+		if v, ok := c.GetIfPresent(1); !ok || v != 1 {
+			t.Fatalf("unexpected value: %v %v", v, ok)
+		}
+	}
+	close(release)
+	c.call(func() {})
+	var st Stats
+	c.Stats(&st)
+	if st.HitCount != 5 || st.DroppedHits != 4 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestGetStrict(t *testing.T) {
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() { currentTime = time.Now }()
+
+	loadErr := errors.New("load failed")
+	var fail int32
+	c := NewLoadingCache(func(k Key) (Value, error) {
+		if atomic.LoadInt32(&fail) != 0 {
+			return nil, loadErr
+		}
+		return mockTime.now().UnixNano(), nil
+	}, WithExpireAfterWrite(time.Minute), WithExecutor(syncExecutor{})).(*localCache)
+	defer c.Close()
+
+	v1, err := c.GetStrict(1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.call(func() {})
+	if v, err := c.GetStrict(1); err != nil || v != v1 {
+		t.Fatalf("unexpected get: %v %v", v, err)
+	}
+	mockTime.add(2 * time.Minute)
+	atomic.StoreInt32(&fail, 1)
+	if v, err := c.GetStrict(1); err != loadErr || v != nil {
+		t.Fatalf("expected load error, actual: %v %v", v, err)
+	}
+	// Get serves the stale value instead.
+	if v, err := c.Get(1); err != nil || v != v1 {
+		t.Fatalf("unexpected get: %v %v", v, err)
+	}
+	atomic.StoreInt32(&fail, 0)
+	if v, err := c.GetStrict(1); err != nil || v == v1 {
+		t.Fatalf("expected new value, actual: %v %v", v, err)
+	}
+}
+
+func TestInvalidateAllThenPut(t *testing.T) {
+	c := New().(*localCache)
+	defer c.Close()
+	for i := 0; i < 10; i++ {
+		c.Put(i, i)
+	}
+	c.InvalidateAll()
+	// Put right after InvalidateAll must survive even if the key existed.
+	c.Put(1, 10)
+	c.Put(20, 20)
+	c.call(func() {})
+	if v, ok := c.GetIfPresent(1); !ok || v != 10 {
+		t.Fatalf("unexpected value: %v %v", v, ok)
+	}
+	if v, ok := c.GetIfPresent(20); !ok || v != 20 {
+		t.Fatalf("unexpected value: %v %v", v, ok)
+	}
+	if n := c.cache.len(); n != 2 {
+		t.Fatalf("unexpected cache size: %d", n)
+	}
+}
+
+func TestInvalidateAllConcurrentPut(t *testing.T) {
+	c := New().(*localCache)
+	defer c.Close()
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				c.Put(g, i)
+				c.InvalidateAll()
+				c.Put(g, i)
+			}
+		}(g)
+	}
+	wg.Wait()
+	c.InvalidateAll()
+	c.Put(1, 1)
+	c.call(func() {})
+	if n := c.cache.len(); n != 1 {
+		t.Fatalf("unexpected cache size: %d", n)
+	}
+	c.call(func() {
+		n := 0
+		c.accessQueue.iterate(func(*entry) bool {
+			n++
+			return true
+		})
+		if n != 1 {
+			t.Errorf("unexpected access queue size: %d", n)
+		}
+	})
+}
+
+func TestWriteCoalesce(t *testing.T) {
+	var mu sync.Mutex
+	var inserted []Value
+	c := New(WithWriteCoalesce(20*time.Millisecond), WithInsertionListener(func(k Key, v Value) {
+		mu.Lock()
+		inserted = append(inserted, v)
+		mu.Unlock()
+	})).(*localCache)
+	defer c.Close()
+
+	c.Put(1, 0)
+	c.call(func() {})
+	for i := 1; i <= 10; i++ {
+		c.Put(1, i)
+		if v, ok := c.GetIfPresent(1); !ok || v != i {
+			t.Fatalf("unexpected value: %v %v", v, ok)
+		}
+	}
+	c.call(func() {})
+	mu.Lock()
+	if len(inserted) != 1 || inserted[0] != 0 {
+		t.Fatalf("unexpected insertions: %v", inserted)
+	}
+	mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	c.call(func() {})
+	mu.Lock()
+	defer mu.Unlock()
+	if len(inserted) != 2 || inserted[1] != 10 {
+		t.Fatalf("unexpected insertions: %v", inserted)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	c := New().(*localCache)
+	if err := c.Health(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.Close()
+	if err := c.Health(); err != ErrClosed {
+		t.Fatalf("expected closed, actual: %v", err)
+	}
+	var nilCache *localCache
+	if err := nilCache.Health(); err != ErrNilCache {
+		t.Fatalf("expected nil cache, actual: %v", err)
+	}
+}
+
+func TestGetNoLoader(t *testing.T) {
+	c := New().(*localCache)
+	defer c.Close()
+	if v, err := c.Get(1); err != ErrNoLoader || v != nil {
+		t.Fatalf("expected no loader error, actual: %v %v", v, err)
+	}
+	if _, err := c.GetStrict(1); err != ErrNoLoader {
+		t.Fatalf("expected no loader error, actual: %v", err)
+	}
+	c.Put(1, 1)
+	if v, err := c.Get(1); err != nil || v != 1 {
+		t.Fatalf("unexpected get: %v %v", v, err)
+	}
+}
+
+func TestGetAndRefreshNoLoader(t *testing.T) {
+	c := NewLoadingCache(nil).(*localCache)
+	defer c.Close()
+	c.Put(1, 1)
+	c.call(func() {})
+	if v, err := c.GetAndRefresh(1); err != ErrNoLoader || v != nil {
+		t.Fatalf("expected no loader error, actual: %v %v", v, err)
+	}
+	if v, ok := c.GetIfPresent(1); !ok || v != 1 {
+		t.Fatalf("unexpected value: %v %v", v, ok)
+	}
+	if c.refreshAsync(c.cache.get(1, c.hash(1)), nil) {
+		t.Fatal("expected no refresh without loader")
+	}
+}
+
+func TestLoadWaitTimeout(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var loads int32
+	c := NewLoadingCache(func(k Key) (Value, error) {
+		if atomic.AddInt32(&loads, 1) == 1 {
+			close(started)
+			<-release
+			return "hung", nil
+		}
+		return "own", nil
+	}, WithLoadWaitTimeout(10*time.Millisecond)).(*localCache)
+	defer c.Close()
+
+	done := make(chan struct{})
+	go func() {
+		c.Get(1)
+		close(done)
+	}()
+	<-started
+	if v, err := c.Get(1); err != nil || v != "own" {
+		t.Fatalf("unexpected get: %v %v", v, err)
+	}
+	close(release)
+	<-done
+	if n := atomic.LoadInt32(&loads); n != 2 {
+		t.Fatalf("unexpected loads: %d", n)
+	}
+}
+
+func TestUtilization(t *testing.T) {
+	c := New(WithMaximumSize(4)).(*localCache)
+	defer c.Close()
+	if u := c.Utilization(); u != 0 {
+		t.Fatalf("unexpected utilization: %v", u)
+	}
+	c.Put(1, 1)
+	c.Put(2, 2)
+	c.Put(3, 3)
+	c.call(func() {})
+	if u := c.Utilization(); u != 0.75 {
+		t.Fatalf("unexpected utilization: %v", u)
+	}
+	unlimited := New().(*localCache)
+	defer unlimited.Close()
+	unlimited.Put(1, 1)
+	if u := unlimited.Utilization(); u != 0 {
+		t.Fatalf("unexpected utilization: %v", u)
+	}
+}
+
+func TestAvailable(t *testing.T) {
+	c := New(WithMaximumSize(4)).(*localCache)
+	defer c.Close()
+	c.Put(1, 1)
+	c.Put(2, 2)
+	c.Put(3, 3)
+	c.call(func() {})
+	if n := c.Available(); n != 1 {
+		t.Fatalf("unexpected available: %d", n)
+	}
+	c.Put(4, 4)
+	c.Put(5, 5)
+	c.call(func() {})
+	if n := c.Available(); n != 0 {
+		t.Fatalf("unexpected available: %d", n)
+	}
+	unlimited := New().(*localCache)
+	defer unlimited.Close()
+	if n := unlimited.Available(); n != math.MaxInt32 {
+		t.Fatalf("unexpected available: %d", n)
+	}
+	if n := unlimited.Cap(); n != 0 {
+		t.Fatalf("unexpected cap: %d", n)
+	}
+	zero := New(WithMaximumSize(0)).(*localCache)
+	defer zero.Close()
+	if n := zero.Cap(); n != 0 {
+		t.Fatalf("unexpected cap: %d", n)
+	}
+	if n := zero.Available(); n != math.MaxInt32 {
+		t.Fatalf("unexpected available: %d", n)
+	}
+}
+
+func TestWaitForLoads(t *testing.T) {
+	var refreshed int32
+	c := NewLoadingCache(func(k Key) (Value, error) {
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&refreshed, 1)
+		return k, nil
+	}).(*localCache)
+	defer c.Close()
+
+	c.WaitForLoads()
+	c.Put(1, 0)
+	c.Put(2, 0)
+	c.Refresh(1)
+	c.Refresh(2)
+	c.WaitForLoads()
+	if n := atomic.LoadInt32(&refreshed); n != 2 {
+		t.Fatalf("unexpected refreshes: %d", n)
+	}
+	c.call(func() {})
+	if v, _ := c.GetIfPresent(2); v != 2 {
+		t.Fatalf("unexpected value: %v", v)
+	}
+}
+
+func TestStrictCapacity(t *testing.T) {
+	c := New(WithMaximumSize(2), WithStrictCapacity()).(*localCache)
+	defer c.Close()
+
+	putBlocked := func(keys ...int) int {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		go c.call(func() {
+			close(started)
+			<-release
+		})
+		<-started
+		for _, k := range keys {
+			c.Put(k, k)
+		}
+		n := c.cache.len()
+		close(release)
+		c.call(func() {})
+		return n
+	}
+	if n := putBlocked(1, 2, 3); n != 0 {
+		t.Fatalf("unexpected size while blocked: %d", n)
+	}
+	if n := c.cache.len(); n != 2 {
+		t.Fatalf("unexpected cache size: %d", n)
+	}
+	c.InvalidateAll()
+	c.SetStrictCapacity(false)
+	if n := putBlocked(1, 2, 3); n != 2 {
+		t.Fatalf("unexpected size while blocked: %d", n)
+	}
+	if n := c.cache.len(); n != 2 {
+		t.Fatalf("unexpected cache size: %d", n)
+	}
+}
+
+func TestRefreshStarted(t *testing.T) {
+	release := make(chan struct{})
+	c := NewLoadingCache(func(k Key) (Value, error) {
+		if k == 1 {
+			<-release
+		}
+		return k, nil
+	}).(*localCache)
+	defer c.Close()
+
+	if !c.Refresh(2) {
+		t.Fatal("expected absent key loaded")
+	}
+	c.Put(1, 0)
+	if !c.Refresh(1) {
+		t.Fatal("expected refresh started")
+	}
+	if c.Refresh(1) {
+		t.Fatal("unexpected refresh while refreshing")
+	}
+	close(release)
+	c.WaitForLoads()
+	plain := New().(*localCache)
+	defer plain.Close()
+	if plain.Refresh(1) {
+		t.Fatal("unexpected refresh without loader")
+	}
+}
+
+func TestMarkStale(t *testing.T) {
+	var loads int32
+	c := NewLoadingCache(func(k Key) (Value, error) {
+		return atomic.AddInt32(&loads, 1), nil
+	}, WithExecutor(syncExecutor{})).(*localCache)
+	defer c.Close()
+
+	c.Put(1, int32(0))
+	c.MarkStale(2)
+	if v, err := c.Get(1); err != nil || v != int32(0) {
+		t.Fatalf("unexpected get: %v %v", v, err)
+	}
+	c.MarkStale(1)
+	// The entry is refreshed synchronously by the executor.
+	if v, err := c.Get(1); err != nil || v != int32(1) {
+		t.Fatalf("unexpected get: %v %v", v, err)
+	}
+	c.call(func() {})
+	if v, err := c.Get(1); err != nil || v != int32(1) {
+		t.Fatalf("unexpected get: %v %v", v, err)
+	}
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Fatalf("unexpected loads: %d", n)
+	}
+}
+
+func TestEvictionPressure(t *testing.T) {
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	c := New(WithMaximumSize(10)).(*localCache)
+	defer c.Close()
+
+	for i := 0; i < 10; i++ {
+		c.Put(i, i)
+	}
+	c.call(func() {})
+	if p := c.EvictionPressure(); p != 0 {
+		t.Fatalf("unexpected pressure: %v", p)
+	}
+	mockTime.add(30 * time.Second)
+	for i := 10; i < 20; i++ {
+		c.Put(i, i)
+	}
+	if p := c.EvictionPressure(); p != 0.5 {
+		t.Fatalf("unexpected pressure: %v", p)
+	}
+	// Insertions without evictions fall out of the window.
+	mockTime.add(40 * time.Second)
+	if p := c.EvictionPressure(); p != 1 {
+		t.Fatalf("unexpected pressure: %v", p)
+	}
+	mockTime.add(time.Minute)
+	if p := c.EvictionPressure(); p != 0 {
+		t.Fatalf("unexpected pressure: %v", p)
+	}
+}
+
+func TestPeek(t *testing.T) {
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	c := New(WithExpireAfterAccess(time.Minute)).(*localCache)
+	defer c.Close()
+
+	c.Put(1, 1)
+	c.call(func() {})
+	en := c.cache.get(1, sum(1))
+	accessTime := en.getAccessTime()
+	mockTime.add(time.Second)
+	if v, ok := c.Peek(1); !ok || v != 1 {
+		t.Fatalf("unexpected peek: %v %v", v, ok)
+	}
+	if _, ok := c.Peek(2); ok {
+		t.Fatal("unexpected peek of absent key")
+	}
+	c.call(func() {})
+	if en.getAccessTime() != accessTime {
+		t.Fatal("access time changed")
+	}
+	if st := c.StatsSnapshot(); st.HitCount != 0 || st.MissCount != 0 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	mockTime.add(time.Minute)
+	if _, ok := c.Peek(1); ok {
+		t.Fatal("unexpected peek of expired entry")
+	}
+}
+
+func TestReplacementListener(t *testing.T) {
+	var replaced []Value
+	c := New(WithReplacementListener(func(k Key, old, new Value) {
+		if k != 1 {
+			t.Errorf("unexpected key: %v", k)
+		}
+		replaced = append(replaced, old, new)
+	})).(*localCache)
+	defer c.Close()
+
+	c.Put(1, "a")
+	c.Put(1, "b")
+	c.PutWithTags(1, "c", "tag")
+	c.Invalidate(1)
+	c.Put(1, "d")
+	if len(replaced) != 4 || replaced[0] != "a" || replaced[1] != "b" || replaced[2] != "b" || replaced[3] != "c" {
+		t.Fatalf("unexpected replacements: %v", replaced)
+	}
+}
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "temporary" }
+func (temporaryError) Temporary() bool { return true }
+
+func TestErrorCacheTTL(t *testing.T) {
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	terminal := errors.New("terminal")
+	var loads int32
+	c := NewLoadingCache(func(k Key) (Value, error) {
+		atomic.AddInt32(&loads, 1)
+		if k == 1 {
+			return nil, terminal
+		}
+		return nil, fmt.Errorf("wrapped: %w", temporaryError{})
+	}, WithErrorCacheTTL(time.Minute)).(*localCache)
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get(1); err != terminal {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := c.Get(2); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+	// The terminal error is cached, the temporary one is retried.
+	if n := atomic.LoadInt32(&loads); n != 4 {
+		t.Fatalf("unexpected loads: %d", n)
+	}
+	mockTime.add(time.Minute)
+	c.Get(1)
+	if n := atomic.LoadInt32(&loads); n != 5 {
+		t.Fatalf("unexpected loads: %d", n)
+	}
+}
+
+func TestAsyncInsertionListener(t *testing.T) {
+	block := make(chan struct{})
+	var inserted int32
+	c := New(WithInsertionListener(func(Key, Value) {
+		<-block
+		atomic.AddInt32(&inserted, 1)
+	}), WithAsyncInsertionListener(2)).(*localCache)
+	defer c.Close()
+
+	// The listener blocks on the first notification, so only two or three of
+	// the others fit in the queue, depending on when it is received.
+	for i := 0; i < 5; i++ {
+		c.Put(i, i)
+	}
+	c.call(func() {})
+	var st Stats
+	c.Stats(&st)
+	if st.DroppedInsertions < 2 || st.DroppedInsertions > 3 {
+		t.Fatalf("unexpected dropped insertions: %d", st.DroppedInsertions)
+	}
+	if c.cache.len() != 5 {
+		t.Fatalf("unexpected cache size: %d", c.cache.len())
+	}
+	close(block)
+	c.Close()
+	for i := 0; i < 100 && atomic.LoadInt32(&inserted)+int32(st.DroppedInsertions) != 5; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&inserted); int(n)+int(st.DroppedInsertions) != 5 {
+		t.Fatalf("unexpected notifications: %d", n)
+	}
+}
+
+func TestLoadSharedEvicted(t *testing.T) {
+	var loads int32
+	start := make(chan struct{})
+	c := NewLoadingCache(func(k Key) (Value, error) {
+		atomic.AddInt32(&loads, 1)
+		<-start
+		return k, nil
+	}, WithMaximumSize(1)).(*localCache)
+	defer c.Close()
+
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := c.Get(1)
+			if err == nil && v != 1 {
+				err = fmt.Errorf("unexpected value: %v", v)
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		c.loadMu.Lock()
+		_, ok := c.loads[1]
+		c.loadMu.Unlock()
+		if ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// Let waiters queue up before the load completes, then evict its entry.
+	time.Sleep(10 * time.Millisecond)
+	close(start)
+	c.Put(2, 2)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Fatalf("unexpected loads: %d", n)
+	}
+}
+
+func TestConsistencyChecks(t *testing.T) {
+	var mu sync.Mutex
+	var inconsistent []Key
+	c := New(WithMaximumSize(10), WithConsistencyChecks(), WithErrorHandler(func(k Key, err error) {
+		if err == ErrInconsistent {
+			mu.Lock()
+			inconsistent = append(inconsistent, k)
+			mu.Unlock()
+		}
+	})).(*localCache)
+	defer c.Close()
+
+	for i := 0; i < 20; i++ {
+		c.Put(i%15, i)
+		c.GetIfPresent(i % 3)
+	}
+	c.Invalidate(12)
+	c.call(func() {})
+	mu.Lock()
+	n := len(inconsistent)
+	mu.Unlock()
+	if n != 0 {
+		t.Fatalf("unexpected inconsistent keys: %v", inconsistent)
+	}
+	// Remove an entry from the cache behind the policy.
+	c.call(func() {
+		en := c.cache.get(14, c.hash(14))
+		c.cache.delete(en)
+		// Check on the second next write.
+		c.uncheckedWrites = drainMax - 2
+	})
+	c.Put(100, 100)
+	c.call(func() {})
+	mu.Lock()
+	n = len(inconsistent)
+	mu.Unlock()
+	if n != 0 {
+		t.Fatalf("unexpected inconsistent keys: %v", inconsistent)
+	}
+	c.Put(101, 101)
+	c.call(func() {})
+	mu.Lock()
+	defer mu.Unlock()
+	if len(inconsistent) != 1 || inconsistent[0] != 14 {
+		t.Fatalf("unexpected inconsistent keys: %v", inconsistent)
+	}
+}
+
+func TestMonotonicClock(t *testing.T) {
+	if d := monotonicTime().Sub(time.Now()); d < -time.Second || d > time.Second {
+		t.Fatalf("unexpected monotonic time offset: %v", d)
+	}
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	c := New(WithExpireAfterWrite(time.Minute), WithMonotonicClock()).(*localCache)
+	defer c.Close()
+	c.Put(1, 1)
+	mockTime.add(30 * time.Second)
+	if _, ok := c.GetIfPresent(1); !ok {
+		t.Fatal("expected entry present")
+	}
+	mockTime.add(31 * time.Second)
+	if _, ok := c.GetIfPresent(1); ok {
+		t.Fatal("expected entry expired")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	c := New(WithExpireAfterWrite(2 * time.Second))
+	defer c.Close()
+
+	if c.Update(1, 1) {
+		t.Fatalf("absent entry must not be updated")
+	}
+	if _, ok := c.GetIfPresent(1); ok {
+		t.Fatalf("entry must not be inserted")
+	}
+	c.Put(1, 1)
+	mockTime.add(1 * time.Second)
+	if !c.Update(1, 2) {
+		t.Fatalf("entry must be updated")
+	}
+	if v, _ := c.GetIfPresent(1); v != 2 {
+		t.Fatalf("unexpected value: %v", v)
+	}
+	// Write time is not reset, so the entry expires as scheduled.
+	mockTime.add(1500 * time.Millisecond)
+	if _, ok := c.GetIfPresent(1); ok {
+		t.Fatalf("entry must be expired")
+	}
+}
+
+func TestInvalidationBroadcaster(t *testing.T) {
+	var nodes []Cache
+	broadcast := func(k Key) {
+		for _, n := range nodes {
+			n.InvalidateLocal(k)
+		}
+	}
+	var broadcasts int32
+	for i := 0; i < 2; i++ {
+		nodes = append(nodes, New(WithInvalidationBroadcaster(func(k Key) {
+			atomic.AddInt32(&broadcasts, 1)
+			broadcast(k)
+		})))
+		defer nodes[i].Close()
+		nodes[i].Put(1, i)
+	}
+	nodes[0].Invalidate(1)
+	for i, n := range nodes {
+		n.(*localCache).call(func() {})
+		if _, ok := n.GetIfPresent(1); ok {
+			t.Fatalf("entry must be invalidated in node %d", i)
+		}
+	}
+	if n := atomic.LoadInt32(&broadcasts); n != 1 {
+		t.Fatalf("unexpected broadcasts: %d", n)
+	}
+}
+
+func TestRefreshServeStale(t *testing.T) {
+	loadCount := 0
+	loader := func(k Key) (Value, error) {
+		loadCount++
+		if loadCount > 1 {
+			return nil, fmt.Errorf("outage: %w", ErrServeStale)
+		}
+		return loadCount, nil
+	}
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	c := NewLoadingCache(loader, WithExpireAfterWrite(2*time.Second),
+		WithExecutor(syncExecutor{}))
+	defer c.Close()
+
+	if v, err := c.Get(1); err != nil || v != 1 {
+		t.Fatalf("unexpected get: %v %v", v, err)
+	}
+	c.(*localCache).call(func() {})
+	mockTime.add(1500 * time.Millisecond)
+	c.Refresh(1)
+	mockTime.add(1500 * time.Millisecond)
+	// The entry is not expired as its write time was reset.
+	if v, ok := c.GetIfPresent(1); !ok || v != 1 {
+		t.Fatalf("unexpected value: %v %v", v, ok)
+	}
+	if loadCount != 2 {
+		t.Fatalf("unexpected load count: %d", loadCount)
+	}
+}
+
+func TestRefreshDebounce(t *testing.T) {
+	loadCount := 0
+	loader := func(k Key) (Value, error) {
+		loadCount++
+		if loadCount > 1 {
+			return nil, errors.New("failed")
+		}
+		return loadCount, nil
+	}
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	wg := sync.WaitGroup{}
+	insFunc := func(Key, Value) {
+		wg.Done()
+	}
+	c := NewLoadingCache(loader, WithExpireAfterWrite(1*time.Second),
+		WithRefreshDebounce(5*time.Second), WithExecutor(syncExecutor{}),
+		WithInsertionListener(insFunc))
+	defer c.Close()
+
+	wg.Add(1)
+	_, err := c.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	mockTime.add(2 * time.Second)
+	for i := 0; i < 10; i++ {
+		v, err := c.Get(1)
+		if err != nil || v.(int) != 1 {
+			t.Fatalf("unexpected get: %v %v", v, err)
+		}
+	}
+	if loadCount != 2 {
+		t.Fatalf("unexpected load count: %v", loadCount)
+	}
+	mockTime.add(5 * time.Second)
+	c.Get(1)
+	if loadCount != 3 {
+		t.Fatalf("unexpected load count: %v", loadCount)
+	}
+}
+
+func TestLoadConcurrent(t *testing.T) {
+	var loadCount int32
+	start := make(chan struct{})
+	loader := func(k Key) (Value, error) {
+		atomic.AddInt32(&loadCount, 1)
+		<-start
+		return k, nil
+	}
+	c := NewLoadingCache(loader).(*localCache)
+	defer c.Close()
+
+	const n = 10
+	var wg sync.WaitGroup
+	var started int32
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			atomic.AddInt32(&started, 1)
+			v, err := c.Get(1)
+			if err != nil || v.(int) != 1 {
+				t.Errorf("unexpected get: %v %v", v, err)
+			}
+		}()
+	}
+	for atomic.LoadInt32(&started) < n {
+		runtime.Gosched()
+	}
+	// Wait for all goroutines waiting on the loading key.
+	time.Sleep(10 * time.Millisecond)
+	close(start)
+	wg.Wait()
+	if n := atomic.LoadInt32(&loadCount); n != 1 {
+		t.Fatalf("unexpected load count: %v", n)
+	}
+}
+
+func TestLoadPromiseTTL(t *testing.T) {
+	loadCount := 0
+	loader := func(k Key) (Value, error) {
+		loadCount++
+		return loadCount, nil
+	}
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	c := NewLoadingCache(loader, WithLoadPromiseTTL(time.Second)).(*localCache)
+	defer c.Close()
+
+	v, err := c.load(1)
+	if err != nil || v.(int) != 1 {
+		t.Fatalf("unexpected load: %v %v", v, err)
+	}
+	mockTime.add(500 * time.Millisecond)
+	v, err = c.load(1)
+	if err != nil || v.(int) != 1 || loadCount != 1 {
+		t.Fatalf("unexpected load: %v %v, count: %v", v, err, loadCount)
+	}
+	mockTime.add(500 * time.Millisecond)
+	v, err = c.load(1)
+	if err != nil || v.(int) != 2 || loadCount != 2 {
+		t.Fatalf("unexpected load: %v %v, count: %v", v, err, loadCount)
+	}
+}
+
+func TestLoadPromiseTTLInvalidate(t *testing.T) {
+	var loads int32
+	loader := func(k Key) (Value, error) {
+		return int(atomic.AddInt32(&loads, 1)), nil
+	}
+	c := NewLoadingCache(loader, WithLoadPromiseTTL(time.Minute)).(*localCache)
+	defer c.Close()
+
+	if v, err := c.Get(1); err != nil || v != 1 {
+		t.Fatalf("unexpected get: %v %v", v, err)
+	}
+	c.call(func() {})
+	c.Invalidate(1)
+	c.call(func() {})
+	if v, err := c.Get(1); err != nil || v != 2 {
+		t.Fatalf("unexpected get after invalidate: %v %v", v, err)
+	}
+	c.InvalidateAll()
+	if v, err := c.Get(1); err != nil || v != 3 {
+		t.Fatalf("unexpected get after invalidate all: %v %v", v, err)
+	}
+	c.Clear(false)
+	if v, err := c.Get(1); err != nil || v != 4 {
+		t.Fatalf("unexpected get after clear: %v %v", v, err)
+	}
+	c.call(func() {})
+	if v, ok := c.GetIfPresent(1); !ok || v != 4 {
+		t.Fatalf("unexpected value: %v %v", v, ok)
+	}
+}
+
+func TestCloseMultiple(t *testing.T) {
+	c := New()
+	start := make(chan bool)
+	const n = 10
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			<-start
+			c.Close()
+		}()
+	}
+	close(start)
+	wg.Wait()
+	// Should not panic
+	c.GetIfPresent(0)
+	c.Put(1, 1)
+	c.Invalidate(0)
+	c.InvalidateAll()
+	c.Close()
+}
+
+func TestEvictionOrder(t *testing.T) {
+	wg := sync.WaitGroup{}
+	insFunc := func(Key, Value) {
+		wg.Done()
+	}
+	c := New(WithMaximumSize(10), WithPolicy("lru"), WithInsertionListener(insFunc))
+	defer c.Close()
+
+	wg.Add(4)
+	for i := 0; i < 4; i++ {
+		c.Put(i, i)
+	}
+	wg.Wait()
+	c.GetIfPresent(1)
+	keys := c.EvictionOrder(3)
+	if len(keys) != 3 || keys[0] != 0 || keys[1] != 2 || keys[2] != 3 {
+		t.Fatalf("unexpected eviction order: %v", keys)
+	}
+	if _, ok := c.GetIfPresent(0); !ok {
+		t.Fatalf("entry must not be removed")
+	}
+}
+
+func TestEvictionOrderSLRU(t *testing.T) {
+	wg := sync.WaitGroup{}
+	insFunc := func(Key, Value) {
+		wg.Done()
+	}
+	c := New(WithMaximumSize(10), WithInsertionListener(insFunc))
+	defer c.Close()
+
+	wg.Add(3)
+	for i := 0; i < 3; i++ {
+		c.Put(i, i)
+	}
+	wg.Wait()
+	// Promote 0 to the protected segment.
+	c.GetIfPresent(0)
+	keys := c.EvictionOrder(10)
+	if len(keys) != 3 || keys[0] != 1 || keys[1] != 2 || keys[2] != 0 {
+		t.Fatalf("unexpected eviction order: %v", keys)
+	}
+}
+
+func TestCollapseWrites(t *testing.T) {
+	var insertions int32
+	var last atomic.Value
+	insFunc := func(k Key, v Value) {
+		atomic.AddInt32(&insertions, 1)
+		last.Store(v)
+	}
+	c := New(WithCollapseWrites(), WithInsertionListener(insFunc))
+	defer c.Close()
+	l := c.(*localCache)
+
+	// Block processing so that all writes are pending.
+	block := make(chan struct{})
+	started := make(chan struct{})
+	go l.call(func() {
+		close(started)
+		<-block
+	})
+	<-started
+	for i := 0; i < 10; i++ {
+		c.Put(1, i)
+	}
+	c.Put(2, 2)
+	close(block)
+	l.call(func() {})
+	if n := atomic.LoadInt32(&insertions); n != 2 {
+		t.Fatalf("unexpected insertions: %d", n)
+	}
+	// Writes after processing are not skipped.
+	c.Put(1, 10)
+	l.call(func() {})
+	if n := atomic.LoadInt32(&insertions); n != 3 {
+		t.Fatalf("unexpected insertions: %d", n)
+	}
+	if v := last.Load(); v != 10 {
+		t.Fatalf("unexpected value: %v", v)
+	}
+	if v, _ := c.GetIfPresent(1); v != 10 {
+		t.Fatalf("unexpected value: %v", v)
+	}
+}
+
+func BenchmarkExpireLRUAfterWrite(b *testing.B) {
+	b.ReportAllocs()
+	// mockTime := newMockTime()
+	// currentTime = mockTime.now
+	c := New(
+		WithExpireAfterWrite(10*time.Microsecond),
+		WithPolicy("lru"),
+		// WithMaximumSize(100),
+	)
+	defer c.Close()
+
+	type value struct {
+		ii float64
 	}
 
 	for i := 0; i < 1000; i++ {
@@ -493,6 +2909,18 @@ func BenchmarkGetSame(b *testing.B) {
 	})
 }
 
+func BenchmarkTouchSame(b *testing.B) {
+	c := New()
+	c.Put("*", "*")
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.TouchIfPresent("*")
+		}
+	})
+}
+
 func cacheSize(c *cache) int {
 	length := 0
 	c.walk(func(*entry) {
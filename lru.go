@@ -33,7 +33,7 @@ func (l *lruCache) write(en *entry) *entry {
 		en.accessList = l.ls.PushFront(en)
 	} else {
 		// Entry has already been added, update its value instead.
-		cen.setValue(en.getValue())
+		cen.copyValue(en)
 		cen.setWriteTime(en.getWriteTime())
 		if cen.accessList == nil {
 			// Entry is loaded to the cache but not yet registered.
@@ -44,8 +44,9 @@ func (l *lruCache) write(en *entry) *entry {
 	}
 	if l.cap > 0 && l.ls.Len() > l.cap {
 		// Remove the last element when capacity exceeded.
-		en = getEntry(l.ls.Back())
-		return l.remove(en)
+		if en = backUnpinned(&l.ls); en != nil {
+			return l.remove(en)
+		}
 	}
 	return nil
 }
@@ -132,7 +133,7 @@ func (l *slruCache) write(en *entry) *entry {
 		en.accessList = l.probationLs.PushFront(en)
 	} else {
 		// Entry has already been added, update its value instead.
-		cen.setValue(en.getValue())
+		cen.copyValue(en)
 		cen.setWriteTime(en.getWriteTime())
 		if cen.accessList == nil {
 			// Entry is loaded to the cache but not yet registered.
@@ -147,8 +148,9 @@ func (l *slruCache) write(en *entry) *entry {
 	if l.probationCap > 0 && l.probationLs.Len() > l.probationCap &&
 		l.length() > (l.probationCap+l.protectedCap) {
 		// Remove the last element when capacity exceeded.
-		en = getEntry(l.probationLs.Back())
-		return l.remove(en)
+		if en = l.backUnpinned(); en != nil {
+			return l.remove(en)
+		}
 	}
 	return nil
 }
@@ -168,6 +170,12 @@ func (l *slruCache) markAccess(en *entry) {
 		l.protectedLs.MoveToFront(en.accessList)
 		return
 	}
+	if l.protectedCap == 0 && l.probationCap > 0 {
+		// There is no protected segment when the capacity is too small,
+		// e.g. 1, so the cache behaves like LRU.
+		l.probationLs.MoveToFront(en.accessList)
+		return
+	}
 	// The entry is currently in the probation segment, promote it to the protected segment.
 	en.listID = protectedSegment
 	l.probationLs.Remove(en.accessList)
@@ -204,11 +212,16 @@ func (l *slruCache) victim() *entry {
 	if l.probationCap <= 0 || l.length() < (l.probationCap+l.protectedCap) {
 		return nil
 	}
-	el := l.probationLs.Back()
-	if el == nil {
-		return nil
+	return l.backUnpinned()
+}
+
+// backUnpinned returns the last entry which is not pinned in the probation
+// segment, or in the protected segment if all entries in probation are pinned.
+func (l *slruCache) backUnpinned() *entry {
+	if en := backUnpinned(&l.probationLs); en != nil {
+		return en
 	}
-	return getEntry(el)
+	return backUnpinned(&l.protectedLs)
 }
 
 // iterate walks through all lists by access time.
@@ -216,3 +229,18 @@ func (l *slruCache) iterate(fn func(en *entry) bool) {
 	iterateListFromBack(&l.protectedLs, fn)
 	iterateListFromBack(&l.probationLs, fn)
 }
+
+// evictionOrder walks through the probation segment then the protected segment
+// as entries in the protected segment are demoted before being evicted.
+func (l *slruCache) evictionOrder(fn func(en *entry) bool) {
+	stop := false
+	iterateListFromBack(&l.probationLs, func(en *entry) bool {
+		if !fn(en) {
+			stop = true
+		}
+		return !stop
+	})
+	if !stop {
+		iterateListFromBack(&l.protectedLs, fn)
+	}
+}
@@ -0,0 +1,59 @@
+package cache
+
+// entryMeta holds metadata of an entry, so that it is always stored in
+// atomic.Value with the same type.
+type entryMeta struct {
+	meta interface{}
+}
+
+func (e *entry) getMeta() interface{} {
+	if m, _ := e.meta.Load().(*entryMeta); m != nil {
+		return m.meta
+	}
+	return nil
+}
+
+func (e *entry) setMeta(meta interface{}) {
+	if meta == nil {
+		if e.meta.Load() == nil {
+			// Never had metadata.
+			return
+		}
+		e.meta.Store((*entryMeta)(nil))
+		return
+	}
+	e.meta.Store(&entryMeta{meta: meta})
+}
+
+// PutWithMeta associates value with k like Put and attaches the given
+// metadata to the entry, e.g. where the value came from. The metadata is
+// opaque to the cache and can be read by GetEntry and RangeWithMeta.
+// It is cleared when the value is replaced by Put or loaded by Get, but kept
+// when the value is refreshed.
+func (c *localCache) PutWithMeta(k Key, v Value, meta interface{}) {
+	if c == nil {
+		return
+	}
+	if c.onOperation != nil {
+		defer c.observe("PutWithMeta", c.now())
+	}
+	now := c.now()
+	c.putAt(k, c.transform(v), meta, now, now)
+}
+
+// RangeWithMeta calls fn for each entry which is not expired, with its key,
+// value and metadata, until fn returns false. Entries added or removed
+// concurrently may or may not be visited.
+func (c *localCache) RangeWithMeta(fn func(k Key, v Value, meta interface{}) bool) {
+	if c == nil {
+		return
+	}
+	now := c.now()
+	stopped := false
+	c.cache.walk(func(en *entry) {
+		if stopped || c.isExpired(en, now) {
+			return
+		}
+		stopped = !fn(en.key, c.valueOf(en), en.getMeta())
+	})
+}
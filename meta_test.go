@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"testing"
+)
+
+func TestPutWithMeta(t *testing.T) {
+	c := New()
+	defer c.Close()
+	l := c.(*localCache)
+
+	c.PutWithMeta(1, 1, "import")
+	c.PutWithMeta(2, 2, "put")
+	c.Put(3, 3)
+	l.call(func() {})
+	e, ok := c.GetEntry(1)
+	if !ok || e.Meta() != "import" {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+	metas := make(map[Key]interface{})
+	c.RangeWithMeta(func(k Key, v Value, meta interface{}) bool {
+		metas[k] = meta
+		return true
+	})
+	if len(metas) != 3 || metas[1] != "import" || metas[2] != "put" || metas[3] != nil {
+		t.Fatalf("unexpected metadata: %v", metas)
+	}
+	// Metadata is cleared by Put.
+	c.Put(1, 10)
+	e, ok = c.GetEntry(1)
+	if !ok || e.Meta() != nil {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+	n := 0
+	c.RangeWithMeta(func(k Key, v Value, meta interface{}) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Fatalf("unexpected number of visited entries: %d", n)
+	}
+}
@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"container/list"
+)
+
+// mruCache is a MRU cache, which evicts the most recently used entry.
+// It suits cyclic or sequential scans larger than the cache, where the
+// entries just accessed are the least likely to be accessed again soon.
+type mruCache struct {
+	cache *cache
+	cap   int
+	ls    list.List
+}
+
+// init initializes cache list.
+func (l *mruCache) init(c *cache, cap int) {
+	l.cache = c
+	l.cap = cap
+	l.ls.Init()
+}
+
+// write adds new entry to the cache and returns evicted entry if necessary.
+// The most recently used entry is evicted before adding a new entry, so that
+// the new entry is kept, unless all entries are pinned and the new entry is
+// evicted instead.
+func (l *mruCache) write(en *entry) *entry {
+	// Fast path
+	if en.accessList != nil {
+		// Entry existed, update its status instead.
+		l.markAccess(en)
+		return nil
+	}
+	cen := l.cache.getOrSet(en)
+	if cen != nil {
+		// Entry has already been added, update its value instead.
+		cen.copyValue(en)
+		cen.setWriteTime(en.getWriteTime())
+		if cen.accessList != nil {
+			l.markAccess(cen)
+			return nil
+		}
+		// Entry is loaded to the cache but not yet registered.
+		en = cen
+	}
+	var ren *entry
+	if l.cap > 0 && l.ls.Len() >= l.cap {
+		if ren = frontUnpinned(&l.ls); ren == nil {
+			// All entries are pinned, evict the new one.
+			l.cache.delete(en)
+			return en
+		}
+		ren = l.remove(ren)
+	}
+	en.accessList = l.ls.PushFront(en)
+	return ren
+}
+
+// access updates cache entry for a get.
+func (l *mruCache) access(en *entry) {
+	if en.accessList != nil {
+		l.markAccess(en)
+	}
+}
+
+// markAccess marks the element has just been accessed.
+// en.accessList must not be null.
+func (l *mruCache) markAccess(en *entry) {
+	l.ls.MoveToFront(en.accessList)
+}
+
+// remove removes an entry from the cache.
+func (l *mruCache) remove(en *entry) *entry {
+	if en.accessList == nil {
+		// Already deleted
+		return nil
+	}
+	l.cache.delete(en)
+	l.ls.Remove(en.accessList)
+	en.accessList = nil
+	return en
+}
+
+// iterate walks through all lists by access time.
+func (l *mruCache) iterate(fn func(en *entry) bool) {
+	iterateListFromBack(&l.ls, fn)
+}
+
+// evictionOrder walks through all entries from the most recently used.
+func (l *mruCache) evictionOrder(fn func(en *entry) bool) {
+	for el := l.ls.Front(); el != nil; el = el.Next() {
+		if !fn(getEntry(el)) {
+			return
+		}
+	}
+}
+
+// frontUnpinned returns the first entry in the list which is not pinned.
+func frontUnpinned(ls *list.List) *entry {
+	for el := ls.Front(); el != nil; el = el.Next() {
+		en := getEntry(el)
+		if !en.getPinned() {
+			return en
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"testing"
+)
+
+func TestMRU(t *testing.T) {
+	c := cache{}
+	l := mruCache{}
+	l.init(&c, 3)
+
+	en := []*entry{
+		newEntry(1, 1, sum(1)),
+		newEntry(2, 2, sum(2)),
+		newEntry(3, 3, sum(3)),
+		newEntry(4, 4, sum(4)),
+		newEntry(5, 5, sum(5)),
+	}
+	for i := 0; i < 3; i++ {
+		if ren := l.write(en[i]); ren != nil {
+			t.Fatalf("unexpected entry removed: %v", ren.key)
+		}
+	}
+	// The newest entry is evicted.
+	ren := l.write(en[3])
+	if ren == nil || ren.key != 3 {
+		t.Fatalf("unexpected entry removed: %v", ren)
+	}
+	// Accessed entry is evicted first.
+	l.access(en[0])
+	ren = l.write(en[4])
+	if ren == nil || ren.key != 1 {
+		t.Fatalf("unexpected entry removed: %v", ren)
+	}
+	var keys []Key
+	l.evictionOrder(func(en *entry) bool {
+		keys = append(keys, en.key)
+		return true
+	})
+	if len(keys) != 3 || keys[0] != 5 || keys[1] != 4 || keys[2] != 2 {
+		t.Fatalf("unexpected eviction order: %v", keys)
+	}
+	if n := cacheSize(&c); n != 3 {
+		t.Fatalf("unexpected cache size: %d", n)
+	}
+	// The new entry is evicted when all entries are pinned.
+	for i := range keys {
+		l.cache.get(keys[i], sum(keys[i])).setPinned(true)
+	}
+	ren = l.write(newEntry(6, 6, sum(6)))
+	if ren == nil || ren.key != 6 || ren.accessList != nil {
+		t.Fatalf("unexpected entry removed: %v", ren)
+	}
+	if n := cacheSize(&c); n != 3 || l.ls.Len() != 3 {
+		t.Fatalf("unexpected cache size: %d %d", n, l.ls.Len())
+	}
+}
+
+func TestMRUResize(t *testing.T) {
+	c := New(WithMaximumSize(4), WithPolicy("mru")).(*localCache)
+	defer c.Close()
+	for i := 1; i <= 4; i++ {
+		c.Put(i, i)
+	}
+	c.call(func() {})
+	c.GetIfPresent(2)
+	c.call(func() {})
+	keys := c.EvictionOrder(4)
+	if len(keys) != 4 || keys[0] != 2 || keys[1] != 4 || keys[2] != 3 || keys[3] != 1 {
+		t.Fatalf("unexpected eviction order: %v", keys)
+	}
+	// Shrinking evicts the most recently used entries.
+	c.call(func() {
+		c.resize(2)
+	})
+	if _, ok := c.GetIfPresent(2); ok {
+		t.Fatal("expected most recently used entry evicted")
+	}
+	if _, ok := c.GetIfPresent(4); ok {
+		t.Fatal("expected most recently added entry evicted")
+	}
+	if c.cache.len() != 2 {
+		t.Fatalf("unexpected cache size: %d", c.cache.len())
+	}
+}
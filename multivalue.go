@@ -0,0 +1,57 @@
+package cache
+
+// multiValue is the value of an entry holding multiple values added by Append.
+type multiValue []Value
+
+// Append adds v to the values associated with k. When the number of values
+// exceeds the limit set by WithMultiValue, the oldest ones are dropped.
+// Each Append copies the existing values, so it costs memory and time
+// proportional to the number of values of the key.
+//
+// Keys used with Append must not be used with Put, which replaces all values.
+// Eviction and expiration apply to all values of the key.
+func (c *localCache) Append(k Key, v Value) {
+	if c == nil {
+		return
+	}
+	if c.onOperation != nil {
+		defer c.observe("Append", c.now())
+	}
+	// Appends are serialized so that concurrent ones are not lost.
+	c.appendMu.Lock()
+	defer c.appendMu.Unlock()
+	old := c.liveValues(k)
+	n := len(old) + 1
+	if c.maxValuesPerKey > 0 && n > c.maxValuesPerKey {
+		old = old[n-c.maxValuesPerKey:]
+		n = c.maxValuesPerKey
+	}
+	values := make(multiValue, 0, n)
+	values = append(values, old...)
+	values = append(values, c.transform(v))
+	c.put(k, values)
+}
+
+// GetValues returns a copy of the values added by Append for k, or nil if
+// k is not present. It records stats and updates access time like GetIfPresent.
+func (c *localCache) GetValues(k Key) []Value {
+	if c == nil {
+		return nil
+	}
+	v, ok := c.GetIfPresent(k)
+	if !ok {
+		return nil
+	}
+	values, _ := v.(multiValue)
+	return append([]Value(nil), values...)
+}
+
+// liveValues returns the values of k if it is present and not expired.
+func (c *localCache) liveValues(k Key) multiValue {
+	en := c.cache.get(k, c.hash(k))
+	if en == nil || c.isExpired(en, c.now()) {
+		return nil
+	}
+	values, _ := en.getValue().(multiValue)
+	return values
+}
@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestAppend(t *testing.T) {
+	c := New(WithMultiValue(3))
+	defer c.Close()
+
+	if v := c.GetValues(1); v != nil {
+		t.Fatalf("unexpected values: %v", v)
+	}
+	for i := 0; i < 5; i++ {
+		c.Append(1, i)
+	}
+	v := c.GetValues(1)
+	if len(v) != 3 || v[0] != 2 || v[1] != 3 || v[2] != 4 {
+		t.Fatalf("unexpected values: %v", v)
+	}
+	// Returned values are a copy.
+	v[0] = 10
+	if v = c.GetValues(1); v[0] != 2 {
+		t.Fatalf("unexpected values: %v", v)
+	}
+}
+
+func TestAppendConcurrent(t *testing.T) {
+	c := New()
+	defer c.Close()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c.Append(1, i)
+		}(i)
+	}
+	wg.Wait()
+	if v := c.GetValues(1); len(v) != 10 {
+		t.Fatalf("unexpected values: %v", v)
+	}
+}
@@ -24,13 +24,25 @@ type entry struct {
 	accessTime int64 // Access atomically - must be aligned on 32-bit
 	// writeTime is the last time this entry was updated.
 	writeTime int64 // Access atomically - must be aligned on 32-bit
+	// refreshTime is the last time this entry was started refreshing.
+	refreshTime int64 // Access atomically - must be aligned on 32-bit
+	// checksum is the checksum of value when integrity check is enabled.
+	checksum uint64 // Access atomically - must be aligned on 32-bit
+	// refreshJitter is the random delay added to refresh time of this entry.
+	refreshJitter int64 // Access atomically - must be aligned on 32-bit
 
 	// FIXME: More efficient way to store boolean flags
 	invalidated int32
 	loading     int32
+	pinned      int32
+	// stale is set by MarkStale until the entry is written again.
+	stale int32
+	// writing is set when a write event of this entry is pending.
+	writing int32
 
 	key   Key
 	value atomic.Value // Store value
+	meta  atomic.Value // Store *entryMeta, see PutWithMeta
 
 	// These properties are managed by only cache policy so do not need atomic access.
 
@@ -40,6 +52,14 @@ type entry struct {
 	writeList *list.Element
 	// listID is ID of the list which this entry is currently in.
 	listID uint8
+
+	// tags is managed by the cache in processEntries goroutine.
+	tags []string
+	// dependsOn is managed by the cache in processEntries goroutine.
+	dependsOn []Key
+	// indexed holds attributes of the value in secondary indexes.
+	// It is managed by the cache in processEntries goroutine.
+	indexed []string
 }
 
 func newEntry(k Key, v Value, h uint64) *entry {
@@ -59,6 +79,21 @@ func (e *entry) setValue(v Value) {
 	e.value.Store(v)
 }
 
+// copyValue copies value, its checksum and metadata from the given entry.
+func (e *entry) copyValue(en *entry) {
+	e.setValue(en.getValue())
+	e.setChecksum(en.getChecksum())
+	e.setMeta(en.getMeta())
+}
+
+func (e *entry) getChecksum() uint64 {
+	return atomic.LoadUint64(&e.checksum)
+}
+
+func (e *entry) setChecksum(v uint64) {
+	atomic.StoreUint64(&e.checksum, v)
+}
+
 func (e *entry) getAccessTime() int64 {
 	return atomic.LoadInt64(&e.accessTime)
 }
@@ -75,6 +110,22 @@ func (e *entry) setWriteTime(v int64) {
 	atomic.StoreInt64(&e.writeTime, v)
 }
 
+func (e *entry) getRefreshTime() int64 {
+	return atomic.LoadInt64(&e.refreshTime)
+}
+
+func (e *entry) setRefreshTime(v int64) {
+	atomic.StoreInt64(&e.refreshTime, v)
+}
+
+func (e *entry) getRefreshJitter() int64 {
+	return atomic.LoadInt64(&e.refreshJitter)
+}
+
+func (e *entry) setRefreshJitter(v int64) {
+	atomic.StoreInt64(&e.refreshJitter, v)
+}
+
 func (e *entry) getLoading() bool {
 	return atomic.LoadInt32(&e.loading) != 0
 }
@@ -98,6 +149,37 @@ func (e *entry) setInvalidated(v bool) {
 	}
 }
 
+func (e *entry) getPinned() bool {
+	return atomic.LoadInt32(&e.pinned) != 0
+}
+
+func (e *entry) setPinned(v bool) {
+	if v {
+		atomic.StoreInt32(&e.pinned, 1)
+	} else {
+		atomic.StoreInt32(&e.pinned, 0)
+	}
+}
+
+func (e *entry) getStale() bool {
+	return atomic.LoadInt32(&e.stale) != 0
+}
+
+func (e *entry) setStale(v bool) {
+	if v {
+		atomic.StoreInt32(&e.stale, 1)
+	} else {
+		atomic.StoreInt32(&e.stale, 0)
+	}
+}
+
+func (e *entry) setWriting(v bool) bool {
+	if v {
+		return atomic.CompareAndSwapInt32(&e.writing, 0, 1)
+	}
+	return atomic.CompareAndSwapInt32(&e.writing, 1, 0)
+}
+
 // getEntry returns the entry attached to the given list element.
 func getEntry(el *list.Element) *entry {
 	return el.Value.(*entry)
@@ -111,36 +193,75 @@ const (
 	eventAccess
 	eventDelete
 	eventClose
+	eventCall
+	eventRefresh
 )
 
+var eventNames = [...]string{
+	eventWrite:   "write",
+	eventAccess:  "access",
+	eventDelete:  "delete",
+	eventClose:   "close",
+	eventCall:    "call",
+	eventRefresh: "refresh",
+}
+
+func (e event) String() string {
+	if int(e) < len(eventNames) {
+		return eventNames[e]
+	}
+	return "unknown"
+}
+
 type entryEvent struct {
 	entry *entry
 	event event
+	// fn is the function to be run for eventCall.
+	fn func()
 }
 
 // cache is a data structure for cache entries.
 type cache struct {
 	size int64                  // Access atomically - must be aligned on 32-bit
 	segs [segmentCount]sync.Map // map[Key]*entry
+	// compacted holds *sync.Map which replaces the segment after compact.
+	compacted [segmentCount]atomic.Value
 }
 
 func (c *cache) get(k Key, h uint64) *entry {
-	seg := c.segment(h)
-	v, ok := seg.Load(k)
-	if ok {
-		return v.(*entry)
+	for {
+		seg := c.segment(h)
+		v, ok := seg.Load(k)
+		if ok {
+			return v.(*entry)
+		}
+		if c.segment(h) == seg {
+			return nil
+		}
+		// The segment has been compacted concurrently and may have been
+		// cleared, so look up the new one.
 	}
-	return nil
 }
 
 func (c *cache) getOrSet(v *entry) *entry {
-	seg := c.segment(v.hash)
-	en, ok := seg.LoadOrStore(v.key, v)
-	if ok {
-		return en.(*entry)
+	stored := false
+	for {
+		seg := c.segment(v.hash)
+		en, ok := seg.LoadOrStore(v.key, v)
+		if !ok {
+			stored = true
+		}
+		if c.segment(v.hash) != seg {
+			// The segment has been compacted concurrently and v may not
+			// be copied, so store it in the new segment again.
+			continue
+		}
+		if ok && (!stored || en != v) {
+			return en.(*entry)
+		}
+		atomic.AddInt64(&c.size, 1)
+		return nil
 	}
-	atomic.AddInt64(&c.size, 1)
-	return nil
 }
 
 func (c *cache) delete(v *entry) {
@@ -155,7 +276,7 @@ func (c *cache) len() int {
 
 func (c *cache) walk(fn func(*entry)) {
 	for i := range c.segs {
-		c.segs[i].Range(func(k, v interface{}) bool {
+		c.seg(i).Range(func(k, v interface{}) bool {
 			fn(v.(*entry))
 			return true
 		})
@@ -163,7 +284,40 @@ func (c *cache) walk(fn func(*entry)) {
 }
 
 func (c *cache) segment(h uint64) *sync.Map {
-	return &c.segs[h&segmentMask]
+	return c.seg(int(h & segmentMask))
+}
+
+func (c *cache) seg(i int) *sync.Map {
+	if m, ok := c.compacted[i].Load().(*sync.Map); ok {
+		return m
+	}
+	return &c.segs[i]
+}
+
+// compact copies entries of each segment to a new one so memory of removed
+// entries can be released. Entries must only be deleted by the caller
+// meanwhile, while they can be added concurrently.
+func (c *cache) compact() {
+	for i := range c.segs {
+		old := c.seg(i)
+		m := &sync.Map{}
+		copySegment := func(k, v interface{}) bool {
+			m.LoadOrStore(k, v)
+			return true
+		}
+		old.Range(copySegment)
+		c.compacted[i].Store(m)
+		// Copy entries added while copying, which getOrSet counted
+		// without noticing the new segment.
+		old.Range(copySegment)
+		if old == &c.segs[i] {
+			// The initial segment is never dropped, so release its entries.
+			old.Range(func(k, v interface{}) bool {
+				old.Delete(k)
+				return true
+			})
+		}
+	}
 }
 
 // policy is a cache policy.
@@ -182,14 +336,34 @@ type policy interface {
 	iterate(func(entry *entry) bool)
 }
 
+// evictionOrderer is an optional interface implemented by policies which can
+// report the entries they would evict next.
+// Policies which do not implement it are iterated by access time instead.
+type evictionOrderer interface {
+	// evictionOrder iterates entries in the order they would be evicted
+	// until fn returns false.
+	evictionOrder(fn func(entry *entry) bool)
+}
+
+// defaultPolicy is the policy used when no policy name is given.
+const defaultPolicy = "slru"
+
 func newPolicy(name string) policy {
 	switch name {
-	case "", "slru":
+	case "", defaultPolicy:
 		return &slruCache{}
 	case "lru":
 		return &lruCache{}
 	case "tinylfu":
 		return &tinyLFU{}
+	case "priority":
+		return &priorityCache{}
+	case "lfu":
+		return &lfuCache{}
+	case "sampled":
+		return &sampledCache{}
+	case "mru":
+		return &mruCache{}
 	default:
 		panic("cache: unsupported policy " + name)
 	}
@@ -205,10 +379,22 @@ func (w *recencyQueue) init(cache *cache, maximumSize int) {
 }
 
 func (w *recencyQueue) write(en *entry) *entry {
-	if en.writeList == nil {
-		en.writeList = w.ls.PushFront(en)
-	} else {
-		w.ls.MoveToFront(en.writeList)
+	// Keep the list sorted by write time. Entries are usually written now and
+	// go to the front, while imported or restored ones may be written earlier.
+	tm := en.getWriteTime()
+	mark := w.ls.Front()
+	for mark != nil && (mark == en.writeList || getEntry(mark).getWriteTime() > tm) {
+		mark = mark.Next()
+	}
+	switch {
+	case en.writeList == nil && mark == nil:
+		en.writeList = w.ls.PushBack(en)
+	case en.writeList == nil:
+		en.writeList = w.ls.InsertBefore(en, mark)
+	case mark == nil:
+		w.ls.MoveToBack(en.writeList)
+	default:
+		w.ls.MoveBefore(en.writeList, mark)
 	}
 	return nil
 }
@@ -258,3 +444,14 @@ func iterateListFromBack(ls *list.List, fn func(en *entry) bool) {
 		el = prev
 	}
 }
+
+// backUnpinned returns the last entry in the list which is not pinned.
+func backUnpinned(ls *list.List) *entry {
+	for el := ls.Back(); el != nil; el = el.Prev() {
+		en := getEntry(el)
+		if !en.getPinned() {
+			return en
+		}
+	}
+	return nil
+}
@@ -25,3 +25,51 @@ func BenchmarkCacheSegment(b *testing.B) {
 		}
 	})
 }
+
+func TestCacheCompact(t *testing.T) {
+	c := cache{}
+	entries := make([]*entry, 100)
+	for i := range entries {
+		entries[i] = newEntry(i, i, uint64(i))
+		c.getOrSet(entries[i])
+	}
+	for _, en := range entries[10:] {
+		c.delete(en)
+	}
+	c.compact()
+	if c.len() != 10 {
+		t.Fatalf("unexpected length: %d", c.len())
+	}
+	for i, en := range entries {
+		if found := c.get(i, uint64(i)); (found == en) != (i < 10) {
+			t.Fatalf("unexpected entry %d: %v", i, found)
+		}
+	}
+	// The initial segments must not keep the entries.
+	for i := range c.segs {
+		c.segs[i].Range(func(k, v interface{}) bool {
+			t.Fatalf("entry %v is kept by segment %d", k, i)
+			return false
+		})
+	}
+	// Entries are added while compacting.
+	done := make(chan struct{})
+	go func() {
+		for _, en := range entries[10:] {
+			if c.getOrSet(en) != nil {
+				t.Errorf("unexpected existing entry: %v", en.key)
+			}
+		}
+		close(done)
+	}()
+	c.compact()
+	<-done
+	if c.len() != 100 {
+		t.Fatalf("unexpected length: %d", c.len())
+	}
+	for i, en := range entries {
+		if c.get(i, uint64(i)) != en {
+			t.Fatalf("missing entry %d", i)
+		}
+	}
+}
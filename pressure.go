@@ -0,0 +1,85 @@
+package cache
+
+import "time"
+
+const (
+	// Window over which eviction pressure is computed.
+	pressureWindow = 1 * time.Minute
+	// Number of time slots in the eviction pressure window.
+	pressureSlots = 6
+)
+
+// pressureSlot counts insertions and evictions within a time slot.
+type pressureSlot struct {
+	slot       int64
+	insertions uint64
+	evictions  uint64
+}
+
+// pressureCounter counts insertions and size evictions in a sliding window.
+// It is managed by the cache in processEntries goroutine.
+type pressureCounter struct {
+	slots [pressureSlots]pressureSlot
+}
+
+// current returns the slot of the given time, resetting it if it is outdated.
+func (p *pressureCounter) current(now time.Time) *pressureSlot {
+	slot := now.UnixNano() / int64(pressureWindow/pressureSlots)
+	s := &p.slots[slot%pressureSlots]
+	if s.slot != slot {
+		*s = pressureSlot{slot: slot}
+	}
+	return s
+}
+
+// sum returns insertions and evictions within the window ending at now.
+func (p *pressureCounter) sum(now time.Time) (insertions, evictions uint64) {
+	slot := now.UnixNano() / int64(pressureWindow/pressureSlots)
+	for i := range p.slots {
+		s := &p.slots[i]
+		if s.slot > slot-pressureSlots && s.slot <= slot {
+			insertions += s.insertions
+			evictions += s.evictions
+		}
+	}
+	return insertions, evictions
+}
+
+// EvictionPressure returns the number of entries evicted because the cache is
+// full divided by the number of entries added in the last minute, counted in
+// slots of 10 seconds. A value close to 1 means almost every new entry evicts
+// another one, i.e. the cache is too small for the working set and thrashing.
+// It returns 0 if no entries were added in the window.
+func (c *localCache) EvictionPressure() float64 {
+	if c == nil {
+		return 0
+	}
+	insertions, evictions := c.pressureSum()
+	return pressureRatio(insertions, evictions)
+}
+
+// pressureSum returns insertions and evictions in the eviction pressure window.
+func (c *localCache) pressureSum() (insertions, evictions uint64) {
+	c.call(func() {
+		insertions, evictions = c.pressure.sum(c.now())
+	})
+	return insertions, evictions
+}
+
+// EvictionPressure returns the eviction pressure of all shards together.
+func (c *shardedCache) EvictionPressure() float64 {
+	var insertions, evictions uint64
+	for _, s := range c.shards {
+		i, e := s.pressureSum()
+		insertions += i
+		evictions += e
+	}
+	return pressureRatio(insertions, evictions)
+}
+
+func pressureRatio(insertions, evictions uint64) float64 {
+	if insertions == 0 {
+		return 0
+	}
+	return float64(evictions) / float64(insertions)
+}
@@ -0,0 +1,201 @@
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+	"sort"
+)
+
+// PriorityFunc returns eviction priority of an entry. Entries with lower
+// priority are evicted first.
+type PriorityFunc func(Key, Value) int
+
+// priorityItem is an entry in the priority heap.
+type priorityItem struct {
+	en       *entry
+	priority int
+	// seq is the insertion sequence used to break ties.
+	seq   uint64
+	index int
+}
+
+// priorityHeap implements heap.Interface ordered by priority, then by
+// insertion order so that older entries are evicted first.
+type priorityHeap []*priorityItem
+
+func (h priorityHeap) Len() int {
+	return len(h)
+}
+
+func (h priorityHeap) Less(i, j int) bool {
+	if h[i].priority == h[j].priority {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].priority < h[j].priority
+}
+
+func (h priorityHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *priorityHeap) Push(x interface{}) {
+	item := x.(*priorityItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *priorityHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*h = old[:n-1]
+	return item
+}
+
+// priorityCache evicts entries with the lowest priority given by a PriorityFunc.
+// Priority of an entry is recomputed when it is written or accessed.
+// Entries are also kept in a list ordered by access time for expiration.
+type priorityCache struct {
+	cache    *cache
+	cap      int
+	priority PriorityFunc
+
+	heap  priorityHeap
+	items map[*entry]*priorityItem
+	seq   uint64
+	ls    list.List
+}
+
+// init initializes the priority heap.
+func (l *priorityCache) init(c *cache, cap int) {
+	l.cache = c
+	l.cap = cap
+	l.heap = nil
+	l.items = make(map[*entry]*priorityItem)
+	l.ls.Init()
+}
+
+// write adds new entry to the cache and returns evicted entry if necessary.
+func (l *priorityCache) write(en *entry) *entry {
+	// Fast path
+	if en.accessList != nil {
+		// Entry existed, update its priority instead.
+		l.markAccess(en)
+		return nil
+	}
+	cen := l.cache.getOrSet(en)
+	if cen == nil {
+		l.push(en)
+	} else {
+		// Entry has already been added, update its value instead.
+		cen.copyValue(en)
+		cen.setWriteTime(en.getWriteTime())
+		if cen.accessList == nil {
+			// Entry is loaded to the cache but not yet registered.
+			l.push(cen)
+		} else {
+			l.markAccess(cen)
+		}
+	}
+	if l.cap > 0 && l.heap.Len() > l.cap {
+		// Remove the entry with lowest priority when capacity exceeded.
+		if en = l.victim(); en != nil {
+			return l.remove(en)
+		}
+	}
+	return nil
+}
+
+// victim returns the unpinned entry with the lowest priority.
+func (l *priorityCache) victim() *entry {
+	if !l.heap[0].en.getPinned() {
+		return l.heap[0].en
+	}
+	// Slow path: find the lowest one among unpinned entries.
+	min := -1
+	for i, item := range l.heap {
+		if !item.en.getPinned() && (min < 0 || l.heap.Less(i, min)) {
+			min = i
+		}
+	}
+	if min < 0 {
+		return nil
+	}
+	return l.heap[min].en
+}
+
+// access recomputes priority of the entry. It does not reorder entries
+// with the same priority.
+func (l *priorityCache) access(en *entry) {
+	if en.accessList != nil {
+		l.markAccess(en)
+	}
+}
+
+// markAccess updates access order and priority of the entry.
+// en.accessList must not be null.
+func (l *priorityCache) markAccess(en *entry) {
+	l.ls.MoveToFront(en.accessList)
+	item := l.items[en]
+	p := l.priorityOf(en)
+	if item.priority != p {
+		item.priority = p
+		heap.Fix(&l.heap, item.index)
+	}
+}
+
+// remove removes an entry from the cache.
+func (l *priorityCache) remove(en *entry) *entry {
+	if en.accessList == nil {
+		// Already deleted
+		return nil
+	}
+	l.cache.delete(en)
+	l.ls.Remove(en.accessList)
+	en.accessList = nil
+	item := l.items[en]
+	delete(l.items, en)
+	heap.Remove(&l.heap, item.index)
+	return en
+}
+
+// iterate walks through all entries by access time.
+func (l *priorityCache) iterate(fn func(en *entry) bool) {
+	iterateListFromBack(&l.ls, fn)
+}
+
+// evictionOrder walks through all entries from the lowest priority.
+func (l *priorityCache) evictionOrder(fn func(en *entry) bool) {
+	h := make(priorityHeap, len(l.heap))
+	copy(h, l.heap)
+	// sort.Slice does not call h.Swap so item indexes are unchanged.
+	sort.Slice(h, h.Less)
+	for _, item := range h {
+		if !fn(item.en) {
+			return
+		}
+	}
+}
+
+func (l *priorityCache) push(en *entry) {
+	l.seq++
+	item := &priorityItem{
+		en:       en,
+		priority: l.priorityOf(en),
+		seq:      l.seq,
+	}
+	l.items[en] = item
+	heap.Push(&l.heap, item)
+	en.accessList = l.ls.PushFront(en)
+}
+
+func (l *priorityCache) priorityOf(en *entry) int {
+	if l.priority == nil {
+		return 0
+	}
+	return l.priority(en.key, en.getValue())
+}
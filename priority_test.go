@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"testing"
+)
+
+func TestPriority(t *testing.T) {
+	c := cache{}
+	p := priorityCache{
+		priority: func(k Key, v Value) int {
+			return v.(int)
+		},
+	}
+	p.init(&c, 3)
+
+	en := []*entry{
+		newEntry(1, 2, sum(1)),
+		newEntry(2, 1, sum(2)),
+		newEntry(3, 3, sum(3)),
+		newEntry(4, 5, sum(4)),
+	}
+	for i := 0; i < 3; i++ {
+		if ren := p.write(en[i]); ren != nil {
+			t.Fatalf("unexpected entry removed: %v", ren.key)
+		}
+	}
+	ren := p.write(en[3])
+	if ren == nil || ren.key != 2 {
+		t.Fatalf("unexpected entry removed: %v", ren)
+	}
+	if n := cacheSize(&c); n != 3 {
+		t.Fatalf("unexpected cache size: %d", n)
+	}
+}
+
+func TestPriorityTieBreak(t *testing.T) {
+	c := cache{}
+	p := priorityCache{}
+	p.init(&c, 2)
+
+	en := []*entry{
+		newEntry(1, 1, sum(1)),
+		newEntry(2, 2, sum(2)),
+		newEntry(3, 3, sum(3)),
+	}
+	p.write(en[0])
+	p.write(en[1])
+	// Access does not change eviction order of entries with the same priority.
+	p.access(en[0])
+	ren := p.write(en[2])
+	if ren == nil || ren.key != 1 {
+		t.Fatalf("unexpected entry removed: %v", ren)
+	}
+	ren = p.write(en[0])
+	if ren == nil || ren.key != 2 {
+		t.Fatalf("unexpected entry removed: %v", ren)
+	}
+}
+
+func TestPriorityAccess(t *testing.T) {
+	c := cache{}
+	prio := map[Key]int{1: 1, 2: 2, 3: 3}
+	p := priorityCache{
+		priority: func(k Key, v Value) int {
+			return prio[k]
+		},
+	}
+	p.init(&c, 2)
+
+	en := []*entry{
+		newEntry(1, 1, sum(1)),
+		newEntry(2, 2, sum(2)),
+		newEntry(3, 3, sum(3)),
+	}
+	p.write(en[0])
+	p.write(en[1])
+	// Priority is recomputed on access.
+	prio[1] = 10
+	p.access(en[0])
+	ren := p.write(en[2])
+	if ren == nil || ren.key != 2 {
+		t.Fatalf("unexpected entry removed: %v", ren)
+	}
+	var keys []Key
+	p.evictionOrder(func(en *entry) bool {
+		keys = append(keys, en.key)
+		return true
+	})
+	if len(keys) != 2 || keys[0] != 3 || keys[1] != 1 {
+		t.Fatalf("unexpected eviction order: %v", keys)
+	}
+}
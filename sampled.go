@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"container/list"
+	"math/rand"
+)
+
+// defaultSampleSize is the number of entries sampled for eviction by default.
+const defaultSampleSize = 5
+
+// sampledItem is an entry of the sampled policy.
+type sampledItem struct {
+	en *entry
+	// lastAccess is the access sequence of the entry.
+	lastAccess uint64
+	index      int
+}
+
+// sampledCache approximates LRU without ordering entries by access. On eviction,
+// it samples a number of random entries and evicts the least recently used one.
+// Access only updates a sequence number of the entry, so it is cheaper than
+// moving the entry in a list, at the cost of eviction precision.
+// Entries are listed in insertion order for iteration, so entries expired by
+// access may be removed only when they are read.
+type sampledCache struct {
+	cache   *cache
+	cap     int
+	samples int
+
+	items []*sampledItem
+	index map[*entry]*sampledItem
+	seq   uint64
+	rand  *rand.Rand
+	ls    list.List
+}
+
+// init initializes the sampled policy.
+func (l *sampledCache) init(c *cache, cap int) {
+	l.cache = c
+	l.cap = cap
+	if l.samples <= 0 {
+		l.samples = defaultSampleSize
+	}
+	l.items = nil
+	l.index = make(map[*entry]*sampledItem)
+	l.rand = rand.New(rand.NewSource(rand.Int63()))
+	l.ls.Init()
+}
+
+// write adds new entry to the cache and returns evicted entry if necessary.
+func (l *sampledCache) write(en *entry) *entry {
+	// Fast path
+	if en.accessList != nil {
+		l.markAccess(en)
+		return nil
+	}
+	cen := l.cache.getOrSet(en)
+	if cen != nil {
+		// Entry has already been added, update its value instead.
+		cen.copyValue(en)
+		cen.setWriteTime(en.getWriteTime())
+		if cen.accessList != nil {
+			l.markAccess(cen)
+			return nil
+		}
+		// Entry is loaded to the cache but not yet registered.
+		en = cen
+	}
+	var ren *entry
+	if l.cap > 0 && len(l.items) >= l.cap {
+		if ren = l.victim(); ren == nil {
+			// All entries are pinned, evict the new one.
+			l.cache.delete(en)
+			return en
+		}
+		ren = l.remove(ren)
+	}
+	l.push(en)
+	return ren
+}
+
+// victim returns the least recently used unpinned entry among random samples.
+func (l *sampledCache) victim() *entry {
+	var min *sampledItem
+	for i := 0; i < l.samples && len(l.items) > 0; i++ {
+		item := l.items[l.rand.Intn(len(l.items))]
+		if !item.en.getPinned() && (min == nil || item.lastAccess < min.lastAccess) {
+			min = item
+		}
+	}
+	if min == nil {
+		// Slow path: all samples are pinned.
+		for _, item := range l.items {
+			if !item.en.getPinned() {
+				return item.en
+			}
+		}
+		return nil
+	}
+	return min.en
+}
+
+// access updates access sequence of the entry.
+func (l *sampledCache) access(en *entry) {
+	if en.accessList != nil {
+		l.markAccess(en)
+	}
+}
+
+// markAccess updates access sequence of the entry.
+// en.accessList must not be null.
+func (l *sampledCache) markAccess(en *entry) {
+	l.seq++
+	l.index[en].lastAccess = l.seq
+}
+
+// remove removes an entry from the cache.
+func (l *sampledCache) remove(en *entry) *entry {
+	if en.accessList == nil {
+		// Already deleted
+		return nil
+	}
+	l.cache.delete(en)
+	l.ls.Remove(en.accessList)
+	en.accessList = nil
+	item := l.index[en]
+	delete(l.index, en)
+	// Move the last item to the removed position.
+	last := l.items[len(l.items)-1]
+	l.items[item.index] = last
+	last.index = item.index
+	l.items[len(l.items)-1] = nil
+	l.items = l.items[:len(l.items)-1]
+	return en
+}
+
+// iterate walks through all entries by insertion order.
+func (l *sampledCache) iterate(fn func(en *entry) bool) {
+	iterateListFromBack(&l.ls, fn)
+}
+
+func (l *sampledCache) push(en *entry) {
+	l.seq++
+	item := &sampledItem{
+		en:         en,
+		lastAccess: l.seq,
+		index:      len(l.items),
+	}
+	l.items = append(l.items, item)
+	l.index[en] = item
+	en.accessList = l.ls.PushFront(en)
+}
@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"testing"
+)
+
+func TestSampled(t *testing.T) {
+	c := cache{}
+	l := sampledCache{samples: 100}
+	l.init(&c, 3)
+
+	en := []*entry{
+		newEntry(1, 1, sum(1)),
+		newEntry(2, 2, sum(2)),
+		newEntry(3, 3, sum(3)),
+		newEntry(4, 4, sum(4)),
+	}
+	for i := 0; i < 3; i++ {
+		if ren := l.write(en[i]); ren != nil {
+			t.Fatalf("unexpected entry removed: %v", ren.key)
+		}
+	}
+	l.access(en[0])
+	// With enough samples, the least recently used entry is evicted.
+	ren := l.write(en[3])
+	if ren == nil || ren.key != 2 {
+		t.Fatalf("unexpected entry removed: %v", ren)
+	}
+	if n := cacheSize(&c); n != 3 || len(l.items) != 3 {
+		t.Fatalf("unexpected cache size: %d", n)
+	}
+	for i, item := range l.items {
+		if item.index != i || l.index[item.en] != item {
+			t.Fatalf("unexpected item: %+v", item)
+		}
+	}
+	en[0].setPinned(true)
+	en[2].setPinned(true)
+	ren = l.write(newEntry(5, 5, sum(5)))
+	if ren == nil || ren.key != 4 {
+		t.Fatalf("unexpected entry removed: %v", ren)
+	}
+}
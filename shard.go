@@ -0,0 +1,586 @@
+package cache
+
+import (
+	"encoding/gob"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// hashRing maps hash values to shards using consistent hashing, so that
+// adding or removing a shard only remaps a small portion of keys.
+type hashRing struct {
+	// points are sorted hash values of shard replicas.
+	points []uint64
+	shards map[uint64]int
+}
+
+// newHashRing creates a ring of the given number of shards, each of them
+// has the given number of replicas (virtual nodes) on the ring.
+func newHashRing(shards, replicas int) *hashRing {
+	r := &hashRing{
+		shards: make(map[uint64]int, shards*replicas),
+	}
+	for i := 0; i < shards; i++ {
+		r.add(i, replicas)
+	}
+	return r
+}
+
+// add places replicas of the shard on the ring.
+func (r *hashRing) add(shard, replicas int) {
+	for i := 0; i < replicas; i++ {
+		p := mix64(uint64(shard)<<32 | uint64(i))
+		if _, ok := r.shards[p]; ok {
+			// Collision, the replica is owned by the existing shard.
+			continue
+		}
+		r.shards[p] = shard
+		r.points = append(r.points, p)
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		return r.points[i] < r.points[j]
+	})
+}
+
+// get returns the shard owning the given hash value.
+func (r *hashRing) get(h uint64) int {
+	h = mix64(h)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i] >= h
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.shards[r.points[i]]
+}
+
+// mix64 is the finalizer of MurmurHash3 to spread hash values on the ring.
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// ShardedCache is a Cache partitioned into multiple shards.
+type ShardedCache interface {
+	Cache
+
+	// ShardStats returns stats of each shard, which reveal load imbalance
+	// among shards. When the shards share a StatsCounter given by
+	// WithStatsCounter, all of them are the same as the total.
+	ShardStats() []Stats
+}
+
+// shardedCache is a Cache partitioned into multiple local caches.
+type shardedCache struct {
+	shards []*localCache
+	ring   *hashRing
+	// sharedStats is true when all shards use the same StatsCounter.
+	sharedStats bool
+	// exec is the executor given by WithExecutor, which is shared by all
+	// shards and closed once with the sharded cache.
+	exec     Executor
+	execOnce sync.Once
+}
+
+// NewConsistentSharded returns a Cache partitioned into the given number of local
+// caches. Shard of a key is selected using a consistent hash ring, where each
+// shard has the given number of replicas, so that changing the number of shards
+// remaps only a small portion of keys.
+//
+// Options are applied to every shard. Maximum size and bounds of WithAdaptiveSize
+// are divided evenly among shards and listeners may be called concurrently from
+// different shards. A StatsCounter given by WithStatsCounter is shared by all
+// shards. An Executor given by WithExecutor is shared by all shards as well and
+// closed once when the sharded cache is closed. It panics if a Policy given by
+// WithCustomPolicy would be shared by multiple shards, WithCustomPolicyFactory
+// must be used instead.
+func NewConsistentSharded(shards int, replicas int, options ...Option) ShardedCache {
+	if shards < 1 {
+		shards = 1
+	}
+	if replicas < 1 {
+		replicas = 1
+	}
+	c := &shardedCache{
+		shards:      make([]*localCache, shards),
+		ring:        newHashRing(shards, replicas),
+		sharedStats: true,
+	}
+	for i := range c.shards {
+		s := newLocalCache()
+		for _, opt := range options {
+			opt(s)
+		}
+		if s.cap > 0 && s.cap < maximumCapacity {
+			// Only a configured size is divided, the default means unlimited.
+			s.cap = int32(shardSize(int(s.cap), shards))
+		}
+		if s.adaptiveMax > 0 {
+			s.adaptiveMin = shardSize(s.adaptiveMin, shards)
+			if s.adaptiveMax < maximumCapacity {
+				s.adaptiveMax = shardSize(s.adaptiveMax, shards)
+			}
+		}
+		if s.customPolicy != nil && shards > 1 {
+			panic("cache: custom policy can not be shared by shards")
+		}
+		if _, ok := s.stats.(*statsCounter); ok {
+			c.sharedStats = false
+		}
+		if s.exec != nil && !s.sharedExec {
+			// Close the executor at the sharded level instead of per shard.
+			c.exec = s.exec
+			s.sharedExec = true
+		}
+		s.init()
+		c.shards[i] = s
+	}
+	return c
+}
+
+// shardSize returns size of a shard when the total size n is divided among
+// the given number of shards, rounding up.
+func shardSize(n, shards int) int {
+	return (n + shards - 1) / shards
+}
+
+// shard returns the local cache which key k belongs to.
+func (c *shardedCache) shard(k Key) *localCache {
+	// Shards have the same hash seed unless it is random, in which case
+	// the seed of the first shard is used.
+	return c.shards[c.ring.get(c.shards[0].hash(k))]
+}
+
+// GetIfPresent gets cached value from the shard of k.
+func (c *shardedCache) GetIfPresent(k Key) (Value, bool) {
+	return c.shard(k).GetIfPresent(k)
+}
+
+// GetEntry returns a snapshot of the entry of k from its shard.
+func (c *shardedCache) GetEntry(k Key) (*EntryView, bool) {
+	return c.shard(k).GetEntry(k)
+}
+
+// TouchIfPresent marks the entry of k accessed if it is present.
+func (c *shardedCache) TouchIfPresent(k Key) bool {
+	return c.shard(k).TouchIfPresent(k)
+}
+
+// Peek returns value of k in its shard without side effects.
+func (c *shardedCache) Peek(k Key) (Value, bool) {
+	return c.shard(k).Peek(k)
+}
+
+// Put adds new entry to the shard of k.
+func (c *shardedCache) Put(k Key, v Value) {
+	c.shard(k).Put(k, v)
+}
+
+// PutWithTimes adds new entry with the given times to the shard of k.
+func (c *shardedCache) PutWithTimes(k Key, v Value, writeTime, accessTime time.Time) {
+	c.shard(k).PutWithTimes(k, v, writeTime, accessTime)
+}
+
+// PutWithMeta adds new entry with metadata to the shard of k.
+func (c *shardedCache) PutWithMeta(k Key, v Value, meta interface{}) {
+	c.shard(k).PutWithMeta(k, v, meta)
+}
+
+// RangeWithMeta calls fn for live entries of all shards until it returns false.
+func (c *shardedCache) RangeWithMeta(fn func(k Key, v Value, meta interface{}) bool) {
+	stopped := false
+	for _, s := range c.shards {
+		s.RangeWithMeta(func(k Key, v Value, meta interface{}) bool {
+			stopped = !fn(k, v, meta)
+			return !stopped
+		})
+		if stopped {
+			return
+		}
+	}
+}
+
+// RangeByAccessOrder ranges over shards one by one, so entries are only
+// ordered by access time within each shard.
+func (c *shardedCache) RangeByAccessOrder(fn func(k Key, v Value) bool) {
+	stopped := false
+	for _, s := range c.shards {
+		s.RangeByAccessOrder(func(k Key, v Value) bool {
+			stopped = !fn(k, v)
+			return !stopped
+		})
+		if stopped {
+			return
+		}
+	}
+}
+
+// GetBySecondary looks up the secondary index in all shards.
+func (c *shardedCache) GetBySecondary(name, attr string) (Value, bool) {
+	for _, s := range c.shards {
+		if _, en := s.lookupSecondary(name, attr); en != nil {
+			return s.GetBySecondary(name, attr)
+		}
+	}
+	// Record the miss.
+	return c.shards[0].GetBySecondary(name, attr)
+}
+
+// Update replaces value of k in its shard if it is present.
+func (c *shardedCache) Update(k Key, v Value) bool {
+	return c.shard(k).Update(k, v)
+}
+
+// GetOrSet returns value associated with k or stores the value created by factory.
+func (c *shardedCache) GetOrSet(k Key, factory func() Value) Value {
+	return c.shard(k).GetOrSet(k, factory)
+}
+
+// Append adds v to the values of k in its shard.
+func (c *shardedCache) Append(k Key, v Value) {
+	c.shard(k).Append(k, v)
+}
+
+// GetValues returns the values of k from its shard.
+func (c *shardedCache) GetValues(k Key) []Value {
+	return c.shard(k).GetValues(k)
+}
+
+// PutWithTags adds new entry with tags to the shard of k.
+func (c *shardedCache) PutWithTags(k Key, v Value, tags ...string) {
+	c.shard(k).PutWithTags(k, v, tags...)
+}
+
+// PutWithDependencies adds new entry with dependencies to the shard of k.
+// Entries in other shards are invalidated when keys they depend on directly
+// are invalidated, but dependencies are followed transitively only within
+// a shard.
+func (c *shardedCache) PutWithDependencies(k Key, v Value, dependsOn ...Key) {
+	c.shard(k).PutWithDependencies(k, v, dependsOn...)
+}
+
+// InvalidateTag discards all entries associated with the tag in all shards.
+func (c *shardedCache) InvalidateTag(tag string) {
+	for _, s := range c.shards {
+		s.InvalidateTag(tag)
+	}
+}
+
+// Invalidate removes the entry associated with key k.
+func (c *shardedCache) Invalidate(k Key) {
+	s := c.shard(k)
+	s.Invalidate(k)
+	c.invalidateDependents(s, k)
+}
+
+// InvalidateLocal removes the entry associated with key k without broadcasting.
+func (c *shardedCache) InvalidateLocal(k Key) {
+	s := c.shard(k)
+	s.InvalidateLocal(k)
+	c.invalidateDependents(s, k)
+}
+
+// invalidateDependents discards entries depending on k in shards other than s.
+func (c *shardedCache) invalidateDependents(s *localCache, k Key) {
+	for _, o := range c.shards {
+		if o != s {
+			o.invalidateDependents(k)
+		}
+	}
+}
+
+// MarkStale makes the entry of k expired in its shard.
+func (c *shardedCache) MarkStale(k Key) {
+	c.shard(k).MarkStale(k)
+}
+
+// Pin marks the entry associated with key k not to be evicted.
+func (c *shardedCache) Pin(k Key) {
+	c.shard(k).Pin(k)
+}
+
+// Unpin allows the entry associated with key k to be evicted again.
+func (c *shardedCache) Unpin(k Key) {
+	c.shard(k).Unpin(k)
+}
+
+// InvalidateAll resets all shards.
+func (c *shardedCache) InvalidateAll() {
+	for _, s := range c.shards {
+		s.InvalidateAll()
+	}
+}
+
+// Clear removes all entries of all shards.
+func (c *shardedCache) Clear(notify bool) {
+	for _, s := range c.shards {
+		s.Clear(notify)
+	}
+}
+
+// PolicyName returns name of the eviction policy of the shards.
+func (c *shardedCache) PolicyName() string {
+	return c.shards[0].PolicyName()
+}
+
+// Find returns matching entries of all shards, up to limit if it is positive.
+func (c *shardedCache) Find(pred func(Key, Value) bool, limit int) map[Key]Value {
+	found := make(map[Key]Value)
+	for _, s := range c.shards {
+		n := 0
+		if limit > 0 {
+			n = limit - len(found)
+			if n <= 0 {
+				break
+			}
+		}
+		for k, v := range s.Find(pred, n) {
+			found[k] = v
+		}
+	}
+	return found
+}
+
+// Drain removes all entries of all shards and returns the live ones.
+func (c *shardedCache) Drain() map[Key]Value {
+	entries := make(map[Key]Value)
+	for _, s := range c.shards {
+		for k, v := range s.Drain() {
+			entries[k] = v
+		}
+	}
+	return entries
+}
+
+// Cap returns total capacity of all shards, or 0 if any shard is unlimited.
+func (c *shardedCache) Cap() int {
+	n := 0
+	for _, s := range c.shards {
+		cap := s.Cap()
+		if cap == 0 {
+			return 0
+		}
+		n += cap
+	}
+	return n
+}
+
+// Available returns total number of entries which can be added to all shards
+// before they start evicting, or math.MaxInt32 if any shard is unlimited.
+func (c *shardedCache) Available() int {
+	n := 0
+	for _, s := range c.shards {
+		a := s.Available()
+		if s.Cap() == 0 {
+			return a
+		}
+		n += a
+	}
+	return n
+}
+
+// SetStrictCapacity sets the capacity enforcement of all shards.
+func (c *shardedCache) SetStrictCapacity(strict bool) {
+	for _, s := range c.shards {
+		s.SetStrictCapacity(strict)
+	}
+}
+
+// Utilization returns the number of entries of all shards divided by their
+// total capacity, or 0 if any shard is unlimited.
+func (c *shardedCache) Utilization() float64 {
+	n, cap := 0, 0
+	for _, s := range c.shards {
+		if s.Cap() == 0 {
+			return 0
+		}
+		n += s.cache.len()
+		cap += s.Cap()
+	}
+	if cap <= 0 {
+		return 0
+	}
+	return float64(n) / float64(cap)
+}
+
+// Stats copies total stats of all shards to t.
+func (c *shardedCache) Stats(t *Stats) {
+	if c.sharedStats {
+		c.shards[0].Stats(t)
+		return
+	}
+	*t = Stats{}
+	var st Stats
+	for _, s := range c.shards {
+		s.Stats(&st)
+		t.add(&st)
+	}
+}
+
+// StatsSnapshot returns a copy of statistics of all shards.
+func (c *shardedCache) StatsSnapshot() Stats {
+	if c.sharedStats {
+		return c.shards[0].StatsSnapshot()
+	}
+	var t Stats
+	for _, s := range c.shards {
+		st := s.StatsSnapshot()
+		t.add(&st)
+	}
+	return t
+}
+
+// Compact compacts all shards.
+func (c *shardedCache) Compact() {
+	for _, s := range c.shards {
+		s.Compact()
+	}
+}
+
+// Health returns the first error of the shards' health checks.
+func (c *shardedCache) Health() error {
+	for _, s := range c.shards {
+		if err := s.Health(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Hits returns total hits of all shards.
+func (c *shardedCache) Hits() uint64 {
+	return c.sum((*localCache).Hits)
+}
+
+// Misses returns total misses of all shards.
+func (c *shardedCache) Misses() uint64 {
+	return c.sum((*localCache).Misses)
+}
+
+// Evictions returns total evictions of all shards.
+func (c *shardedCache) Evictions() uint64 {
+	return c.sum((*localCache).Evictions)
+}
+
+// sum returns total of the counter of all shards.
+func (c *shardedCache) sum(counter func(*localCache) uint64) uint64 {
+	if c.sharedStats {
+		return counter(c.shards[0])
+	}
+	var n uint64
+	for _, s := range c.shards {
+		n += counter(s)
+	}
+	return n
+}
+
+// ShardStats returns stats of each shard.
+func (c *shardedCache) ShardStats() []Stats {
+	st := make([]Stats, len(c.shards))
+	for i, s := range c.shards {
+		s.Stats(&st[i])
+	}
+	return st
+}
+
+// ResetStats zeros stats of all shards.
+func (c *shardedCache) ResetStats() {
+	if c.sharedStats {
+		c.shards[0].ResetStats()
+		return
+	}
+	for _, s := range c.shards {
+		s.ResetStats()
+	}
+}
+
+// EvictionOrder returns up to limit keys which would be evicted, taking
+// candidates from each shard in turn.
+func (c *shardedCache) EvictionOrder(limit int) []Key {
+	orders := make([][]Key, len(c.shards))
+	for i, s := range c.shards {
+		orders[i] = s.EvictionOrder(limit)
+	}
+	var keys []Key
+	for i := 0; len(keys) < limit; i++ {
+		found := false
+		for _, o := range orders {
+			if i < len(o) && len(keys) < limit {
+				keys = append(keys, o[i])
+				found = true
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	return keys
+}
+
+// ExpiredCountEstimate returns total estimated expired entries of all shards.
+func (c *shardedCache) ExpiredCountEstimate() int {
+	n := 0
+	for _, s := range c.shards {
+		n += s.ExpiredCountEstimate()
+	}
+	return n
+}
+
+// DumpTo writes all live entries of all shards in gob format to w.
+func (c *shardedCache) DumpTo(w io.Writer) error {
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(&dumpHeader{Version: dumpVersion}); err != nil {
+		return err
+	}
+	for _, s := range c.shards {
+		if err := s.dumpEntries(enc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreFrom adds entries written by DumpTo to their shards.
+func (c *shardedCache) RestoreFrom(r io.Reader) error {
+	return readDump(r, func(k Key) uint64 {
+		return c.shard(k).hash(k)
+	}, func(en *entry) {
+		s := c.shard(en.key)
+		en.setValue(s.compress(en.getValue()))
+		s.setEntryChecksum(en)
+		s.sendEvent(eventWrite, en)
+	})
+}
+
+// Close closes all shards.
+func (c *shardedCache) Close() error {
+	return c.CloseWithTimeout(0)
+}
+
+// CloseWithTimeout closes all shards concurrently and waits up to d.
+func (c *shardedCache) CloseWithTimeout(d time.Duration) error {
+	errs := make(chan error, len(c.shards))
+	for _, s := range c.shards {
+		go func(s *localCache) {
+			errs <- s.CloseWithTimeout(d)
+		}(s)
+	}
+	var err error
+	for range c.shards {
+		if e := <-errs; e != nil {
+			err = e
+		}
+	}
+	if c.exec != nil {
+		c.execOnce.Do(func() {
+			c.exec.Close()
+		})
+	}
+	return err
+}
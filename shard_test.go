@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"bytes"
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHashRingRemap(t *testing.T) {
+	const shards = 10
+	const keys = 10000
+	r1 := newHashRing(shards, 100)
+	r2 := newHashRing(shards+1, 100)
+
+	ringMoved := 0
+	modMoved := 0
+	count := make([]int, shards)
+	for i := 0; i < keys; i++ {
+		h := sum(i)
+		s := r1.get(h)
+		count[s]++
+		if s != r2.get(h) {
+			ringMoved++
+		}
+		if h%shards != h%(shards+1) {
+			modMoved++
+		}
+	}
+	// Ideally only 1/(shards+1) of keys are moved to the new shard.
+	if ringMoved > keys/(shards+1)*2 || ringMoved*4 > modMoved {
+		t.Fatalf("unexpected remapped keys: ring=%d modulo=%d", ringMoved, modMoved)
+	}
+	for i, n := range count {
+		if n < keys/shards/2 || n > keys/shards*2 {
+			t.Fatalf("unbalanced shard %d: %d keys", i, n)
+		}
+	}
+}
+
+func TestConsistentSharded(t *testing.T) {
+	wg := sync.WaitGroup{}
+	insFunc := func(Key, Value) {
+		wg.Done()
+	}
+	c := NewConsistentSharded(4, 16, WithMaximumSize(100), WithInsertionListener(insFunc)).(*shardedCache)
+	defer c.Close()
+
+	if c.shards[0].cap != 25 {
+		t.Fatalf("unexpected shard capacity: %d", c.shards[0].cap)
+	}
+	const n = 20
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		c.Put(i, i)
+	}
+	wg.Wait()
+	for i := 0; i < n; i++ {
+		v, ok := c.GetIfPresent(i)
+		if !ok || v.(int) != i {
+			t.Fatalf("unexpected get: %v %v", v, ok)
+		}
+	}
+	var st Stats
+	c.Stats(&st)
+	if st.HitCount != n {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+
+	var buf bytes.Buffer
+	if err := c.DumpTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	r := NewConsistentSharded(2, 16, WithInsertionListener(insFunc))
+	defer r.Close()
+	wg.Add(n)
+	if err := r.RestoreFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	for i := 0; i < n; i++ {
+		v, ok := r.GetIfPresent(i)
+		if !ok || v.(int) != i {
+			t.Fatalf("unexpected get: %v %v", v, ok)
+		}
+	}
+}
+
+func TestConsistentShardedSize(t *testing.T) {
+	c := NewConsistentSharded(4, 16).(*shardedCache)
+	defer c.Close()
+	if c.shards[0].cap != maximumCapacity {
+		t.Fatalf("unexpected shard capacity: %d", c.shards[0].cap)
+	}
+	c.Put(1, 1)
+	if u := c.Utilization(); u != 0 {
+		t.Fatalf("unexpected utilization: %v", u)
+	}
+	if n := c.Cap(); n != 0 {
+		t.Fatalf("unexpected cap: %d", n)
+	}
+	if n := c.Available(); n != math.MaxInt32 {
+		t.Fatalf("unexpected available: %d", n)
+	}
+
+	a := NewConsistentSharded(4, 16, WithAdaptiveSize(10, 100)).(*shardedCache)
+	defer a.Close()
+	s := a.shards[0]
+	if s.adaptiveMin != 3 || s.adaptiveMax != 25 || s.cap != 3 {
+		t.Fatalf("unexpected adaptive size: %d %d %d", s.adaptiveMin, s.adaptiveMax, s.cap)
+	}
+}
+
+func TestConsistentShardedCustomPolicy(t *testing.T) {
+	c := NewConsistentSharded(2, 16, WithCustomPolicyFactory(func() Policy {
+		return &fifoPolicy{}
+	}), WithMaximumSize(4)).(*shardedCache)
+	defer c.Close()
+	if c.shards[0].customPolicy == c.shards[1].customPolicy {
+		t.Fatal("expected a policy per shard")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic for shared policy")
+		}
+	}()
+	NewConsistentSharded(2, 16, WithCustomPolicy(&fifoPolicy{}))
+}
+
+func TestConsistentShardedExecutor(t *testing.T) {
+	e := &closeCountingExecutor{}
+	c := NewConsistentSharded(4, 16, WithExecutor(e))
+	c.Close()
+	c.Close()
+	if n := atomic.LoadInt32(&e.closed); n != 1 {
+		t.Fatalf("expected executor closed once, actual: %d", n)
+	}
+}
+
+func TestShardStats(t *testing.T) {
+	c := NewConsistentSharded(4, 16)
+	defer c.Close()
+
+	const n = 20
+	for i := 0; i < n; i++ {
+		c.GetIfPresent(i)
+	}
+	st := c.ShardStats()
+	if len(st) != 4 {
+		t.Fatalf("unexpected shard stats: %v", st)
+	}
+	var total uint64
+	for i := range st {
+		total += st[i].MissCount
+	}
+	if total != n {
+		t.Fatalf("unexpected total misses: %d", total)
+	}
+	if c.Misses() != n || c.Hits() != 0 {
+		t.Fatalf("unexpected counters: %d %d", c.Misses(), c.Hits())
+	}
+}
@@ -14,6 +14,27 @@ type Stats struct {
 	LoadErrorCount   uint64
 	TotalLoadTime    time.Duration
 	EvictionCount    uint64
+	// LoadedBytes is the total weight of successfully loaded values.
+	// It is only recorded when a Weigher is set or values implement Sizer.
+	LoadedBytes uint64
+	// UncompressedBytes and CompressedBytes are the total size of values before
+	// and after compression. They are only recorded when compression is enabled.
+	UncompressedBytes uint64
+	CompressedBytes   uint64
+	// MissCountByReason is MissCount broken down by MissReason.
+	MissCountByReason [missReasonCount]uint64
+	// RefreshSuccessCount, RefreshErrorCount and TotalRefreshTime are about
+	// reloads of existing entries, which are not included in load stats.
+	RefreshSuccessCount uint64
+	RefreshErrorCount   uint64
+	TotalRefreshTime    time.Duration
+	// DroppedHits is the number of accesses not applied to the cache policy
+	// because the events channel was full. See WithNonBlockingHits.
+	DroppedHits uint64
+	// DroppedInsertions is the number of notifications not delivered to the
+	// insertion listener because its queue was full.
+	// See WithAsyncInsertionListener.
+	DroppedInsertions uint64
 }
 
 // RequestCount returns a total of HitCount and MissCount.
@@ -57,10 +78,48 @@ func (s *Stats) AverageLoadPenalty() time.Duration {
 	return s.TotalLoadTime / time.Duration(total)
 }
 
+// RefreshErrorRate returns the ratio of refreshes which returned errors.
+func (s *Stats) RefreshErrorRate() float64 {
+	total := s.RefreshSuccessCount + s.RefreshErrorCount
+	if total == 0 {
+		return 0.0
+	}
+	return float64(s.RefreshErrorCount) / float64(total)
+}
+
+// CompressionRatio returns the ratio of compressed size to original size of values.
+func (s *Stats) CompressionRatio() float64 {
+	if s.UncompressedBytes == 0 {
+		return 1.0
+	}
+	return float64(s.CompressedBytes) / float64(s.UncompressedBytes)
+}
+
+// add adds values of the given stats to s.
+func (s *Stats) add(t *Stats) {
+	s.HitCount += t.HitCount
+	s.MissCount += t.MissCount
+	s.LoadSuccessCount += t.LoadSuccessCount
+	s.LoadErrorCount += t.LoadErrorCount
+	s.TotalLoadTime += t.TotalLoadTime
+	s.EvictionCount += t.EvictionCount
+	s.LoadedBytes += t.LoadedBytes
+	s.UncompressedBytes += t.UncompressedBytes
+	s.CompressedBytes += t.CompressedBytes
+	for i := range s.MissCountByReason {
+		s.MissCountByReason[i] += t.MissCountByReason[i]
+	}
+	s.RefreshSuccessCount += t.RefreshSuccessCount
+	s.RefreshErrorCount += t.RefreshErrorCount
+	s.TotalRefreshTime += t.TotalRefreshTime
+	s.DroppedHits += t.DroppedHits
+	s.DroppedInsertions += t.DroppedInsertions
+}
+
 // String returns a string representation of this statistics.
 func (s *Stats) String() string {
-	return fmt.Sprintf("hits: %d, misses: %d, successes: %d, errors: %d, time: %s, evictions: %d",
-		s.HitCount, s.MissCount, s.LoadSuccessCount, s.LoadErrorCount, s.TotalLoadTime, s.EvictionCount)
+	return fmt.Sprintf("hits: %d, misses: %d, successes: %d, errors: %d, time: %s, evictions: %d, loaded bytes: %d",
+		s.HitCount, s.MissCount, s.LoadSuccessCount, s.LoadErrorCount, s.TotalLoadTime, s.EvictionCount, s.LoadedBytes)
 }
 
 // StatsCounter accumulates statistics of a cache.
@@ -84,6 +143,178 @@ type StatsCounter interface {
 	Snapshot(*Stats)
 }
 
+// WeightStatsCounter is a StatsCounter which also accumulates weight of loaded values.
+// It is used instead of RecordLoadSuccess when the cache has a Weigher.
+type WeightStatsCounter interface {
+	StatsCounter
+
+	// RecordLoadSuccessWeight records successful load of a new entry with the given weight.
+	RecordLoadSuccessWeight(loadTime time.Duration, weight uint64)
+}
+
+// EvictionCause is the reason an entry was evicted from the cache.
+type EvictionCause uint8
+
+const (
+	// EvictionSize means the entry was evicted due to the cache size limit.
+	EvictionSize EvictionCause = iota
+	// EvictionExpired means the entry was removed as it expired.
+	EvictionExpired
+
+	evictionCauseCount
+)
+
+// String returns name of the eviction cause.
+func (c EvictionCause) String() string {
+	switch c {
+	case EvictionSize:
+		return "size"
+	case EvictionExpired:
+		return "expired"
+	default:
+		return "unknown"
+	}
+}
+
+// CauseStatsCounter is a StatsCounter which also records causes of evictions.
+// It is used instead of RecordEviction when the cache knows the cause.
+type CauseStatsCounter interface {
+	StatsCounter
+
+	// RecordEvictionCause records eviction of an entry with the given cause.
+	RecordEvictionCause(cause EvictionCause)
+}
+
+// MissReason is the reason of a cache miss.
+type MissReason uint8
+
+const (
+	// MissAbsent means the key was not in the cache.
+	MissAbsent MissReason = iota
+	// MissExpiredAccess means the entry expired after access.
+	MissExpiredAccess
+	// MissExpiredWrite means the entry expired after write.
+	MissExpiredWrite
+	// MissInvalidated means the entry was invalidated.
+	MissInvalidated
+	// MissCorrupted means the entry did not match its checksum.
+	MissCorrupted
+	// MissStale means the entry was marked stale by MarkStale.
+	MissStale
+
+	missReasonCount
+)
+
+// String returns name of the miss reason.
+func (r MissReason) String() string {
+	switch r {
+	case MissAbsent:
+		return "absent"
+	case MissExpiredAccess:
+		return "expired by access"
+	case MissExpiredWrite:
+		return "expired by write"
+	case MissInvalidated:
+		return "invalidated"
+	case MissCorrupted:
+		return "corrupted"
+	case MissStale:
+		return "stale"
+	default:
+		return "unknown"
+	}
+}
+
+// MissReasonStatsCounter is a StatsCounter which also records reasons of misses.
+// RecordMissReason is called in addition to RecordMisses.
+type MissReasonStatsCounter interface {
+	StatsCounter
+
+	// RecordMissReason records a cache miss with the given reason.
+	RecordMissReason(reason MissReason)
+}
+
+// CompressionStatsCounter is a StatsCounter which also records compression of values.
+type CompressionStatsCounter interface {
+	StatsCounter
+
+	// RecordCompression records compression of a value from the original size
+	// to the compressed size.
+	RecordCompression(uncompressed, compressed uint64)
+}
+
+// RefreshStatsCounter is a StatsCounter which records refreshes of existing
+// entries separately from loads. When the cache has a StatsCounter which does
+// not implement it, refreshes are recorded as loads.
+type RefreshStatsCounter interface {
+	StatsCounter
+
+	// RecordRefreshSuccess records successful refresh of an entry.
+	RecordRefreshSuccess(loadTime time.Duration)
+
+	// RecordRefreshError records failed refresh of an entry.
+	RecordRefreshError(loadTime time.Duration)
+}
+
+// DroppedHitStatsCounter is a StatsCounter which also records accesses dropped
+// by a cache with WithNonBlockingHits.
+type DroppedHitStatsCounter interface {
+	StatsCounter
+
+	// RecordDroppedHit records an access which was not applied to the policy.
+	RecordDroppedHit()
+}
+
+// DroppedInsertionStatsCounter is a StatsCounter which also records
+// notifications dropped by a cache with WithAsyncInsertionListener.
+type DroppedInsertionStatsCounter interface {
+	StatsCounter
+
+	// RecordDroppedInsertion records a notification which was not delivered
+	// to the insertion listener.
+	RecordDroppedInsertion()
+}
+
+// ResettableStatsCounter is a StatsCounter which counters can be reset to zero.
+type ResettableStatsCounter interface {
+	StatsCounter
+
+	// Reset zeros all counters.
+	Reset()
+}
+
+// counterReader reads individual counters without taking a snapshot.
+// Caches with a StatsCounter which does not implement it fall back to Snapshot.
+type counterReader interface {
+	Hits() uint64
+	Misses() uint64
+	Evictions() uint64
+}
+
+// NoopStatsCounter is a StatsCounter which records nothing, avoiding the cost
+// of updating counters for caches which do not need stats. See WithNoStats.
+type NoopStatsCounter struct{}
+
+// RecordHits does nothing.
+func (NoopStatsCounter) RecordHits(count uint64) {}
+
+// RecordMisses does nothing.
+func (NoopStatsCounter) RecordMisses(count uint64) {}
+
+// RecordLoadSuccess does nothing.
+func (NoopStatsCounter) RecordLoadSuccess(loadTime time.Duration) {}
+
+// RecordLoadError does nothing.
+func (NoopStatsCounter) RecordLoadError(loadTime time.Duration) {}
+
+// RecordEviction does nothing.
+func (NoopStatsCounter) RecordEviction() {}
+
+// Snapshot zeros t.
+func (NoopStatsCounter) Snapshot(t *Stats) {
+	*t = Stats{}
+}
+
 // statsCounter is a simple implementation of StatsCounter.
 type statsCounter struct {
 	Stats
@@ -99,23 +330,79 @@ func (s *statsCounter) RecordMisses(count uint64) {
 	atomic.AddUint64(&s.Stats.MissCount, count)
 }
 
+// RecordMissReason increases the miss count of the reason atomically.
+func (s *statsCounter) RecordMissReason(reason MissReason) {
+	if reason < missReasonCount {
+		atomic.AddUint64(&s.Stats.MissCountByReason[reason], 1)
+	}
+}
+
 // RecordLoadSuccess increases LoadSuccessCount atomically.
 func (s *statsCounter) RecordLoadSuccess(loadTime time.Duration) {
 	atomic.AddUint64(&s.Stats.LoadSuccessCount, 1)
 	atomic.AddInt64((*int64)(&s.Stats.TotalLoadTime), int64(loadTime))
 }
 
+// RecordLoadSuccessWeight increases LoadSuccessCount and LoadedBytes atomically.
+func (s *statsCounter) RecordLoadSuccessWeight(loadTime time.Duration, weight uint64) {
+	s.RecordLoadSuccess(loadTime)
+	atomic.AddUint64(&s.Stats.LoadedBytes, weight)
+}
+
+// RecordCompression increases UncompressedBytes and CompressedBytes atomically.
+func (s *statsCounter) RecordCompression(uncompressed, compressed uint64) {
+	atomic.AddUint64(&s.Stats.UncompressedBytes, uncompressed)
+	atomic.AddUint64(&s.Stats.CompressedBytes, compressed)
+}
+
 // RecordLoadError increases LoadErrorCount atomically.
 func (s *statsCounter) RecordLoadError(loadTime time.Duration) {
 	atomic.AddUint64(&s.Stats.LoadErrorCount, 1)
 	atomic.AddInt64((*int64)(&s.Stats.TotalLoadTime), int64(loadTime))
 }
 
+// RecordRefreshSuccess increases RefreshSuccessCount atomically.
+func (s *statsCounter) RecordRefreshSuccess(loadTime time.Duration) {
+	atomic.AddUint64(&s.Stats.RefreshSuccessCount, 1)
+	atomic.AddInt64((*int64)(&s.Stats.TotalRefreshTime), int64(loadTime))
+}
+
+// RecordRefreshError increases RefreshErrorCount atomically.
+func (s *statsCounter) RecordRefreshError(loadTime time.Duration) {
+	atomic.AddUint64(&s.Stats.RefreshErrorCount, 1)
+	atomic.AddInt64((*int64)(&s.Stats.TotalRefreshTime), int64(loadTime))
+}
+
+// RecordDroppedHit increases DroppedHits atomically.
+func (s *statsCounter) RecordDroppedHit() {
+	atomic.AddUint64(&s.Stats.DroppedHits, 1)
+}
+
+// RecordDroppedInsertion increases DroppedInsertions atomically.
+func (s *statsCounter) RecordDroppedInsertion() {
+	atomic.AddUint64(&s.Stats.DroppedInsertions, 1)
+}
+
 // RecordEviction increases EvictionCount atomically.
 func (s *statsCounter) RecordEviction() {
 	atomic.AddUint64(&s.Stats.EvictionCount, 1)
 }
 
+// Hits returns HitCount atomically.
+func (s *statsCounter) Hits() uint64 {
+	return atomic.LoadUint64(&s.HitCount)
+}
+
+// Misses returns MissCount atomically.
+func (s *statsCounter) Misses() uint64 {
+	return atomic.LoadUint64(&s.MissCount)
+}
+
+// Evictions returns EvictionCount atomically.
+func (s *statsCounter) Evictions() uint64 {
+	return atomic.LoadUint64(&s.EvictionCount)
+}
+
 // Snapshot copies current stats to t.
 func (s *statsCounter) Snapshot(t *Stats) {
 	t.HitCount = atomic.LoadUint64(&s.HitCount)
@@ -124,4 +411,38 @@ func (s *statsCounter) Snapshot(t *Stats) {
 	t.LoadErrorCount = atomic.LoadUint64(&s.LoadErrorCount)
 	t.TotalLoadTime = time.Duration(atomic.LoadInt64((*int64)(&s.TotalLoadTime)))
 	t.EvictionCount = atomic.LoadUint64(&s.EvictionCount)
+	t.LoadedBytes = atomic.LoadUint64(&s.LoadedBytes)
+	t.UncompressedBytes = atomic.LoadUint64(&s.UncompressedBytes)
+	t.CompressedBytes = atomic.LoadUint64(&s.CompressedBytes)
+	for i := range t.MissCountByReason {
+		t.MissCountByReason[i] = atomic.LoadUint64(&s.MissCountByReason[i])
+	}
+	t.RefreshSuccessCount = atomic.LoadUint64(&s.RefreshSuccessCount)
+	t.RefreshErrorCount = atomic.LoadUint64(&s.RefreshErrorCount)
+	t.TotalRefreshTime = time.Duration(atomic.LoadInt64((*int64)(&s.TotalRefreshTime)))
+	t.DroppedHits = atomic.LoadUint64(&s.DroppedHits)
+	t.DroppedInsertions = atomic.LoadUint64(&s.DroppedInsertions)
+}
+
+// Reset zeros all counters atomically. Each counter is reset independently,
+// so a concurrent Snapshot never sees a partially written value, but it may
+// see some counters reset and others not yet.
+func (s *statsCounter) Reset() {
+	atomic.StoreUint64(&s.HitCount, 0)
+	atomic.StoreUint64(&s.MissCount, 0)
+	atomic.StoreUint64(&s.LoadSuccessCount, 0)
+	atomic.StoreUint64(&s.LoadErrorCount, 0)
+	atomic.StoreInt64((*int64)(&s.TotalLoadTime), 0)
+	atomic.StoreUint64(&s.EvictionCount, 0)
+	atomic.StoreUint64(&s.LoadedBytes, 0)
+	atomic.StoreUint64(&s.UncompressedBytes, 0)
+	atomic.StoreUint64(&s.CompressedBytes, 0)
+	for i := range s.MissCountByReason {
+		atomic.StoreUint64(&s.MissCountByReason[i], 0)
+	}
+	atomic.StoreUint64(&s.RefreshSuccessCount, 0)
+	atomic.StoreUint64(&s.RefreshErrorCount, 0)
+	atomic.StoreInt64((*int64)(&s.TotalRefreshTime), 0)
+	atomic.StoreUint64(&s.DroppedHits, 0)
+	atomic.StoreUint64(&s.DroppedInsertions, 0)
 }
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"errors"
 	"testing"
 	"time"
 )
@@ -51,3 +52,199 @@ func TestStatsCounter(t *testing.T) {
 		t.Fatalf("unexpected load penalty: %v", st.AverageLoadPenalty())
 	}
 }
+
+func TestStatsLoadedBytes(t *testing.T) {
+	c := statsCounter{}
+	c.RecordLoadSuccess(time.Second)
+	c.RecordLoadSuccessWeight(time.Second, 10)
+
+	var st Stats
+	c.Snapshot(&st)
+	if st.LoadSuccessCount != 2 {
+		t.Fatalf("unexpected success count: %v", st)
+	}
+	if st.LoadedBytes != 10 {
+		t.Fatalf("unexpected loaded bytes: %v", st)
+	}
+}
+
+func TestStatsCounterReset(t *testing.T) {
+	c := New()
+	defer c.Close()
+	c.GetIfPresent(1)
+	c.Put(1, 1)
+	c.(*localCache).call(func() {})
+	c.GetIfPresent(1)
+
+	var st Stats
+	c.Stats(&st)
+	if st.HitCount != 1 || st.MissCount != 1 {
+		t.Fatalf("unexpected stats: %v", &st)
+	}
+	if c.Hits() != 1 || c.Misses() != 1 || c.Evictions() != 0 {
+		t.Fatalf("unexpected counters: %d %d %d", c.Hits(), c.Misses(), c.Evictions())
+	}
+	c.ResetStats()
+	c.Stats(&st)
+	if st != (Stats{}) {
+		t.Fatalf("unexpected stats: %v", &st)
+	}
+	c.GetIfPresent(1)
+	c.Stats(&st)
+	if st.HitCount != 1 || st.MissCount != 0 {
+		t.Fatalf("unexpected stats: %v", &st)
+	}
+}
+
+func TestStatsMissReason(t *testing.T) {
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	c := New(WithExpireAfterWrite(1 * time.Second))
+	defer c.Close()
+	l := c.(*localCache)
+
+	c.GetIfPresent(1)
+	c.Put(1, 1)
+	c.Put(2, 2)
+	l.call(func() {})
+	// Mark invalidated without deleting so the miss reason is deterministic.
+	l.cache.get(2, sum(2)).setInvalidated(true)
+	c.GetIfPresent(2)
+	c.Put(3, 3)
+	l.call(func() {})
+	c.MarkStale(3)
+	c.GetIfPresent(3)
+	mockTime.add(2 * time.Second)
+	c.GetIfPresent(1)
+
+	var st Stats
+	c.Stats(&st)
+	if st.MissCount != 4 {
+		t.Fatalf("unexpected miss count: %v", &st)
+	}
+	if st.MissCountByReason[MissCorrupted] != 0 {
+		t.Fatalf("unexpected corrupted miss count: %v", st.MissCountByReason)
+	}
+	for _, r := range []MissReason{MissAbsent, MissInvalidated, MissStale, MissExpiredWrite} {
+		if st.MissCountByReason[r] != 1 {
+			t.Fatalf("unexpected %s miss count: %v", r, st.MissCountByReason)
+		}
+	}
+
+	// Checksum mismatches are corrupted misses in every read path.
+	c = New(WithIntegrityCheck(func(v Value) uint64 {
+		return uint64(len(v.(string)))
+	}), WithSecondaryIndex("value", func(v Value) string {
+		return v.(string)
+	}))
+	defer c.Close()
+	l = c.(*localCache)
+	for i, v := range []string{"a", "b", "c"} {
+		c.Put(i, v)
+	}
+	l.call(func() {})
+	for i := 0; i < 3; i++ {
+		l.cache.get(i, sum(i)).setChecksum(0)
+	}
+	c.GetIfPresent(0)
+	l.GetEntry(1)
+	l.GetBySecondary("value", "c")
+	st = Stats{}
+	c.Stats(&st)
+	if st.MissCount != 3 || st.MissCountByReason[MissCorrupted] != 3 {
+		t.Fatalf("unexpected corrupted miss count: %v", st.MissCountByReason)
+	}
+}
+
+func TestStatsRefresh(t *testing.T) {
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	fail := false
+	c := NewLoadingCache(func(k Key) (Value, error) {
+		if fail {
+			return nil, errors.New("failed")
+		}
+		return k, nil
+	}, WithExpireAfterWrite(time.Minute), WithExecutor(syncExecutor{}))
+	defer c.Close()
+	l := c.(*localCache)
+
+	c.Get(1)
+	l.call(func() {})
+	mockTime.add(2 * time.Minute)
+	c.Get(1)
+	l.call(func() {})
+	fail = true
+	mockTime.add(2 * time.Minute)
+	c.Get(1)
+	l.call(func() {})
+
+	var st Stats
+	c.Stats(&st)
+	if st.LoadSuccessCount != 1 || st.LoadErrorCount != 0 {
+		t.Fatalf("unexpected load stats: %v", &st)
+	}
+	if st.RefreshSuccessCount != 1 || st.RefreshErrorCount != 1 || st.RefreshErrorRate() != 0.5 {
+		t.Fatalf("unexpected refresh stats: %+v", st)
+	}
+}
+
+func TestStatsSnapshot(t *testing.T) {
+	c := New()
+	defer c.Close()
+	c.Put(1, 1)
+	c.GetIfPresent(1)
+	c.GetIfPresent(2)
+	var st Stats
+	c.Stats(&st)
+	if c.StatsSnapshot() != st || st.HitCount != 1 || st.MissCount != 1 {
+		t.Fatalf("unexpected stats: %+v", c.StatsSnapshot())
+	}
+}
+
+func TestNoStats(t *testing.T) {
+	c := NewLoadingCache(func(k Key) (Value, error) {
+		return k, nil
+	}, WithNoStats(), WithMaximumSize(1))
+	defer c.Close()
+	c.Get(1)
+	c.Get(1)
+	c.Get(2)
+	if st := c.StatsSnapshot(); st != (Stats{}) {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	if c.Hits() != 0 || c.Misses() != 0 {
+		t.Fatalf("unexpected counters: %d %d", c.Hits(), c.Misses())
+	}
+}
+
+func BenchmarkStats(b *testing.B) {
+	var c Cache = New()
+	defer c.Close()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		st := new(Stats)
+		c.Stats(st)
+		if st.HitCount != 0 {
+			b.Fatal(st)
+		}
+	}
+}
+
+func BenchmarkStatsSnapshot(b *testing.B) {
+	var c Cache = New()
+	defer c.Close()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		st := c.StatsSnapshot()
+		if st.HitCount != 0 {
+			b.Fatal(st)
+		}
+	}
+}
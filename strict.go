@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidConfig is wrapped in errors returned by NewStrict and
+// NewLoadingCacheStrict for suspicious configuration.
+var ErrInvalidConfig = errors.New("cache: invalid configuration")
+
+// NewStrict returns a local in-memory Cache like New, but returns an error
+// wrapping ErrInvalidConfig instead if the options are likely a mistake,
+// e.g. a zero expiration duration, which New treats as no expiration, or
+// refresh options for a cache without a loader.
+func NewStrict(options ...Option) (Cache, error) {
+	c := newLocalCache()
+	for _, opt := range options {
+		opt(c)
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	c.init()
+	return c, nil
+}
+
+// NewLoadingCacheStrict returns a new LoadingCache like NewLoadingCache, but
+// validates the options as NewStrict.
+func NewLoadingCacheStrict(loader LoaderFunc, options ...Option) (LoadingCache, error) {
+	c := newLocalCache()
+	c.loader = loader
+	for _, opt := range options {
+		opt(c)
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	c.init()
+	return c, nil
+}
+
+// checkDuration records an invalid option if d is not positive.
+func (c *localCache) checkDuration(name string, d time.Duration) {
+	if d <= 0 {
+		c.invalidOption("%s duration must be positive: %v", name, d)
+	}
+}
+
+// invalidOption records a problem of the options, which is only reported by
+// the strict constructors.
+func (c *localCache) invalidOption(format string, args ...interface{}) {
+	if c.configErr == nil {
+		c.configErr = fmt.Errorf("%w: "+format, append([]interface{}{ErrInvalidConfig}, args...)...)
+	}
+}
+
+// validate returns the first problem of the options applied to the cache.
+func (c *localCache) validate() error {
+	if c.configErr != nil {
+		return c.configErr
+	}
+	if c.loader == nil {
+		if c.refreshAfterWrite > 0 {
+			c.invalidOption("WithRefreshAfterWrite requires a loader")
+		} else if c.refreshFunc != nil {
+			c.invalidOption("WithRefreshFunc requires a loader")
+		} else if c.loadFallback != nil {
+			c.invalidOption("WithLoadFallback requires a loader")
+		}
+	}
+	if c.refreshJitter > 0 && c.refreshAfterWrite <= 0 {
+		c.invalidOption("WithRefreshJitter requires WithRefreshAfterWrite")
+	}
+	if c.expireAfterWrite > 0 && c.refreshAfterWrite >= c.expireAfterWrite {
+		c.invalidOption("refresh after write %v is not less than expire after write %v",
+			c.refreshAfterWrite, c.expireAfterWrite)
+	}
+	return c.configErr
+}
@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewStrict(t *testing.T) {
+	loader := func(k Key) (Value, error) {
+		return k, nil
+	}
+	c, err := NewStrict(WithMaximumSize(10), WithExpireAfterWrite(time.Minute))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.Close()
+	l, err := NewLoadingCacheStrict(loader, WithRefreshAfterWrite(time.Minute))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	l.Close()
+
+	invalid := [][]Option{
+		{WithExpireAfterWrite(0)},
+		{WithExpireAfterAccess(-time.Second)},
+		{WithRefreshAfterWrite(time.Minute)},
+		{WithRefreshJitter(0.1)},
+	}
+	for _, options := range invalid {
+		if _, err := NewStrict(options...); !errors.Is(err, ErrInvalidConfig) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	_, err = NewLoadingCacheStrict(loader, WithRefreshAfterWrite(time.Minute), WithExpireAfterWrite(time.Minute))
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// New ignores invalid options.
+	c = New(WithExpireAfterWrite(0))
+	c.Close()
+}
@@ -0,0 +1,81 @@
+package cache
+
+import "sync/atomic"
+
+// PutWithTags associates value with k like Put and replaces tags of the entry
+// with the given ones, so that it can be invalidated by any of its tags.
+// Tags of an entry are kept when its value is replaced by Put.
+//
+// The cache maintains an index from tags to entries, which costs memory
+// proportional to the number of tags of all entries. The index is updated
+// asynchronously and entries are removed from it when they are evicted,
+// expired or invalidated.
+func (c *localCache) PutWithTags(k Key, v Value, tags ...string) {
+	if c == nil {
+		return
+	}
+	if c.onOperation != nil {
+		defer c.observe("PutWithTags", c.now())
+	}
+	en := c.put(k, c.transform(v))
+	if en != nil && atomic.LoadInt32(&c.closing) == 0 {
+		c.enqueue(entryEvent{event: eventCall, fn: func() {
+			c.setTags(en, tags)
+		}})
+	}
+}
+
+// InvalidateTag discards all entries associated with the tag.
+func (c *localCache) InvalidateTag(tag string) {
+	if c == nil {
+		return
+	}
+	if c.onOperation != nil {
+		defer c.observe("InvalidateTag", c.now())
+	}
+	c.call(func() {
+		for en := range c.tags[tag] {
+			en.setInvalidated(true)
+			c.remove(en)
+			c.cascade(en.key)
+		}
+	})
+}
+
+// setTags replaces tags of the entry in the tag index.
+// This function must only be called from processEntries goroutine.
+func (c *localCache) setTags(en *entry, tags []string) {
+	if en.accessList == nil {
+		// The entry has been removed.
+		return
+	}
+	c.untag(en)
+	if len(tags) == 0 {
+		return
+	}
+	if c.tags == nil {
+		c.tags = make(map[string]map[*entry]struct{})
+	}
+	for _, tag := range tags {
+		m := c.tags[tag]
+		if m == nil {
+			m = make(map[*entry]struct{})
+			c.tags[tag] = m
+		}
+		m[en] = struct{}{}
+	}
+	en.tags = tags
+}
+
+// untag removes the entry from the tag index.
+// This function must only be called from processEntries goroutine.
+func (c *localCache) untag(en *entry) {
+	for _, tag := range en.tags {
+		m := c.tags[tag]
+		delete(m, en)
+		if len(m) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+	en.tags = nil
+}
@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestTags(t *testing.T) {
+	wg := sync.WaitGroup{}
+	remFunc := func(Key, Value) {
+		wg.Done()
+	}
+	c := New(WithMaximumSize(3), WithPolicy("lru"), WithRemovalListener(remFunc)).(*localCache)
+
+	c.PutWithTags(1, 1, "a", "b")
+	c.PutWithTags(2, 2, "b")
+	c.PutWithTags(3, 3, "c")
+	c.call(func() {})
+
+	wg.Add(2)
+	c.InvalidateTag("b")
+	wg.Wait()
+	if _, ok := c.GetIfPresent(1); ok {
+		t.Fatalf("expect not present")
+	}
+	if _, ok := c.GetIfPresent(2); ok {
+		t.Fatalf("expect not present")
+	}
+	if _, ok := c.GetIfPresent(3); !ok {
+		t.Fatalf("expect present")
+	}
+	c.call(func() {
+		if len(c.tags) != 1 || len(c.tags["c"]) != 1 {
+			t.Errorf("unexpected tags: %v", c.tags)
+		}
+	})
+
+	// Evicted entries are removed from the index.
+	wg.Add(1)
+	for i := 4; i < 7; i++ {
+		c.Put(i, i)
+	}
+	wg.Wait()
+	c.call(func() {
+		if len(c.tags) != 0 {
+			t.Errorf("unexpected tags: %v", c.tags)
+		}
+	})
+	wg.Add(cacheSize(&c.cache))
+	c.Close()
+}
@@ -17,15 +17,18 @@ type tinyLFU struct {
 
 	additions int
 	samples   int
+	// sampleFactor scales the number of samples before the sketch is reset.
+	sampleFactor float64
 
 	lru  lruCache
 	slru slruCache
 }
 
 func (l *tinyLFU) init(c *cache, cap int) {
+	l.additions = 0
 	if cap > 0 {
 		// Only enable doorkeeper when capacity is finite.
-		l.samples = samplesMultiplier * cap
+		l.samples = l.sampleSize(cap)
 		l.filter.init(insertionsMultiplier*cap, falsePositiveProbability)
 		l.counter.init(countersMultiplier * cap)
 	}
@@ -34,6 +37,18 @@ func (l *tinyLFU) init(c *cache, cap int) {
 	l.slru.init(c, cap-lruCap)
 }
 
+// sampleSize returns the number of additions after which frequencies are halved.
+func (l *tinyLFU) sampleSize(cap int) int {
+	if l.sampleFactor <= 0 {
+		return samplesMultiplier * cap
+	}
+	n := int(float64(samplesMultiplier*cap) * l.sampleFactor)
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
 func (l *tinyLFU) write(en *entry) *entry {
 	if l.lru.cap <= 0 {
 		return l.slru.write(en)
@@ -102,3 +117,19 @@ func (l *tinyLFU) iterate(fn func(en *entry) bool) {
 	l.slru.iterate(fn)
 	l.lru.iterate(fn)
 }
+
+// evictionOrder walks through the admission window then the main segments.
+// This is best-effort as window candidates may still be admitted to the main
+// segments depending on their frequency.
+func (l *tinyLFU) evictionOrder(fn func(en *entry) bool) {
+	stop := false
+	l.lru.iterate(func(en *entry) bool {
+		if !fn(en) {
+			stop = true
+		}
+		return !stop
+	})
+	if !stop {
+		l.slru.evictionOrder(fn)
+	}
+}
@@ -117,3 +117,22 @@ func TestTinyLFU(t *testing.T) {
 		t.Fatalf("unexpected estimate: %d %+v", n, en[2])
 	}
 }
+
+func TestTinyLFUSampleFactor(t *testing.T) {
+	c := cache{}
+	l := tinyLFU{}
+	l.init(&c, 100)
+	if l.samples != 800 {
+		t.Fatalf("unexpected samples: %d", l.samples)
+	}
+	l = tinyLFU{sampleFactor: 2.5}
+	l.init(&c, 100)
+	if l.samples != 2000 {
+		t.Fatalf("unexpected samples: %d", l.samples)
+	}
+	lc := New(WithMaximumSize(100), WithPolicy("tinylfu"), WithSketchResetSampleFactor(0.5))
+	defer lc.Close()
+	if n := lc.(*localCache).accessQueue.(*tinyLFU).samples; n != 400 {
+		t.Fatalf("unexpected samples: %d", n)
+	}
+}
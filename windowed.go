@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// evictionBucket holds eviction counts by cause within a time slot.
+type evictionBucket struct {
+	slot   int64
+	counts [evictionCauseCount]int
+}
+
+// WindowedStatsCounter is a StatsCounter which also counts evictions by cause
+// in time buckets, so that evictions in a recent time window can be queried.
+// Memory is bounded by the number of buckets, which is window / granularity.
+type WindowedStatsCounter struct {
+	statsCounter
+
+	mu          sync.Mutex
+	granularity int64
+	buckets     []evictionBucket
+}
+
+// NewWindowedStatsCounter returns a WindowedStatsCounter keeping evictions for
+// the given window in buckets of the given granularity.
+// Evictions can only be queried in multiples of the granularity.
+func NewWindowedStatsCounter(window, granularity time.Duration) *WindowedStatsCounter {
+	if granularity <= 0 {
+		granularity = time.Second
+	}
+	if window < granularity {
+		window = granularity
+	}
+	n := int((window + granularity - 1) / granularity)
+	return &WindowedStatsCounter{
+		granularity: int64(granularity),
+		buckets:     make([]evictionBucket, n),
+	}
+}
+
+// RecordEviction records an eviction which cause is unknown as caused by size.
+func (s *WindowedStatsCounter) RecordEviction() {
+	s.RecordEvictionCause(EvictionSize)
+}
+
+// RecordEvictionCause increases EvictionCount and the eviction count of
+// the cause in current time bucket.
+func (s *WindowedStatsCounter) RecordEvictionCause(cause EvictionCause) {
+	s.statsCounter.RecordEviction()
+	if cause >= evictionCauseCount {
+		return
+	}
+	slot := currentTime().UnixNano() / s.granularity
+	s.mu.Lock()
+	b := &s.buckets[slot%int64(len(s.buckets))]
+	if b.slot != slot {
+		*b = evictionBucket{slot: slot}
+	}
+	b.counts[cause]++
+	s.mu.Unlock()
+}
+
+// EvictionsInWindow returns number of evictions of all causes in the last d,
+// which is rounded up to the granularity and limited by the window.
+func (s *WindowedStatsCounter) EvictionsInWindow(d time.Duration) int {
+	n := 0
+	s.walk(d, func(b *evictionBucket) {
+		for _, c := range b.counts {
+			n += c
+		}
+	})
+	return n
+}
+
+// EvictionsInWindowByCause returns number of evictions of the cause in the last d.
+func (s *WindowedStatsCounter) EvictionsInWindowByCause(cause EvictionCause, d time.Duration) int {
+	if cause >= evictionCauseCount {
+		return 0
+	}
+	n := 0
+	s.walk(d, func(b *evictionBucket) {
+		n += b.counts[cause]
+	})
+	return n
+}
+
+// walk calls fn for buckets within the last d.
+func (s *WindowedStatsCounter) walk(d time.Duration, fn func(*evictionBucket)) {
+	n := int((int64(d) + s.granularity - 1) / s.granularity)
+	if n > len(s.buckets) {
+		n = len(s.buckets)
+	}
+	slot := currentTime().UnixNano() / s.granularity
+	s.mu.Lock()
+	for i := 0; i < n; i++ {
+		b := &s.buckets[(slot-int64(i))%int64(len(s.buckets))]
+		if b.slot == slot-int64(i) {
+			fn(b)
+		}
+	}
+	s.mu.Unlock()
+}
+
+// Reset zeros all counters and eviction buckets.
+func (s *WindowedStatsCounter) Reset() {
+	s.statsCounter.Reset()
+	s.mu.Lock()
+	for i := range s.buckets {
+		s.buckets[i] = evictionBucket{}
+	}
+	s.mu.Unlock()
+}
@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowedStatsCounter(t *testing.T) {
+	mockTime := newMockTime()
+	currentTime = mockTime.now
+	defer func() {
+		currentTime = time.Now
+	}()
+	c := NewWindowedStatsCounter(time.Minute, time.Second)
+	c.RecordEvictionCause(EvictionSize)
+	c.RecordEvictionCause(EvictionExpired)
+	mockTime.add(30 * time.Second)
+	c.RecordEvictionCause(EvictionExpired)
+	c.RecordEviction()
+
+	if n := c.EvictionsInWindow(time.Second); n != 2 {
+		t.Fatalf("unexpected evictions: %d", n)
+	}
+	if n := c.EvictionsInWindow(time.Minute); n != 4 {
+		t.Fatalf("unexpected evictions: %d", n)
+	}
+	if n := c.EvictionsInWindowByCause(EvictionExpired, time.Minute); n != 2 {
+		t.Fatalf("unexpected evictions: %d", n)
+	}
+	mockTime.add(40 * time.Second)
+	if n := c.EvictionsInWindow(time.Hour); n != 2 {
+		t.Fatalf("unexpected evictions: %d", n)
+	}
+	var st Stats
+	c.Snapshot(&st)
+	if st.EvictionCount != 4 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestWindowedStatsCounterCache(t *testing.T) {
+	st := NewWindowedStatsCounter(time.Minute, time.Second)
+	c := New(WithMaximumSize(1), WithPolicy("lru"), WithStatsCounter(st)).(*localCache)
+	defer c.Close()
+
+	c.Put(1, 1)
+	c.Put(2, 2)
+	c.call(func() {})
+	if n := st.EvictionsInWindowByCause(EvictionSize, time.Minute); n != 1 {
+		t.Fatalf("unexpected evictions: %d", n)
+	}
+}